@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rueian/godemand/metrics"
+)
+
+// UnknownPool is the pool label of requests to pools not in the config, which keeps the cardinality of metrics bounded.
+const UnknownPool = "unknown"
+
+type httpOptions struct {
+	accessLog *accessLogger
+	known     func(poolID string) bool
+}
+
+type HTTPOptionFunc func(o *httpOptions)
+
+// WithAccessLog writes an AccessLog of each request to the writer as a line of json.
+func WithAccessLog(w io.Writer) HTTPOptionFunc {
+	return func(o *httpOptions) {
+		o.accessLog = &accessLogger{enc: json.NewEncoder(w)}
+	}
+}
+
+// WithKnownPools decides which pool ids are used as the pool label of metrics, and others are labeled as UnknownPool.
+// By default, the pools in the config of the Service are known, or none if the service is not a *Service.
+func WithKnownPools(known func(poolID string) bool) HTTPOptionFunc {
+	return func(o *httpOptions) {
+		o.known = known
+	}
+}
+
+// AccessLog is a served request, where the Latency is in milliseconds.
+type AccessLog struct {
+	Time     time.Time
+	Endpoint string
+	Pool     string `json:",omitempty"`
+	Client   string `json:",omitempty"`
+	Resource string `json:",omitempty"`
+	Code     int
+	Latency  float64
+	Remote   string
+}
+
+type accessLogger struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (l *accessLogger) write(entry AccessLog) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.enc.Encode(entry)
+}
+
+// accessWriter captures the status code and the id of the resource written by writeRes.
+type accessWriter struct {
+	http.ResponseWriter
+	code     int
+	resource string
+}
+
+func (w *accessWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// instrument records the count and the latency of requests to the endpoint by pool and status code,
+// where 429 are the requests failed to acquire the lock of the pool, and writes the access log if enabled.
+func (o *httpOptions) instrument(endpoint string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		w := &accessWriter{ResponseWriter: writer}
+		handler(w, request)
+		latency := time.Since(start)
+		if w.code == 0 {
+			w.code = http.StatusOK
+		}
+
+		poolID := request.Form.Get("poolID")
+		label := poolID
+		if poolID != "" && (o.known == nil || !o.known(poolID)) {
+			label = UnknownPool
+		}
+		metrics.RecordHTTPRequest(endpoint, label, w.code, latency)
+
+		if o.accessLog == nil {
+			return
+		}
+		entry := AccessLog{
+			Time:     start,
+			Endpoint: endpoint,
+			Pool:     poolID,
+			Resource: request.Form.Get("id"),
+			Code:     w.code,
+			Latency:  float64(latency) / float64(time.Millisecond),
+			Remote:   request.RemoteAddr,
+		}
+		if w.resource != "" {
+			entry.Resource = w.resource
+		}
+		var client struct{ ID string }
+		if json.Unmarshal([]byte(request.Form.Get("client")), &client) == nil {
+			entry.Client = client.ID
+		}
+		o.accessLog.write(entry)
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/metrics"
+	"github.com/rueian/godemand/plugin"
+	"github.com/rueian/godemand/types"
+	"github.com/rueian/godemand/types/mock"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+var _ = Describe("HTTP access", func() {
+	var ctrl *gomock.Controller
+	var service *mock.MockService
+	var logs bytes.Buffer
+	var client types.Client
+	var known func(poolID string) bool
+
+	BeforeEach(func() {
+		ctrl = gomock.NewController(GinkgoT())
+		service = mock.NewMockService(ctrl)
+		logs.Reset()
+		client = types.Client{ID: "client1"}
+		known = func(id string) bool { return id == "pool1" }
+		Expect(view.Register(metrics.HTTPRequestView, metrics.HTTPLatencyView)).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		view.Unregister(metrics.HTTPRequestView, metrics.HTTPLatencyView)
+		ctrl.Finish()
+	})
+
+	request := func(poolID string) int {
+		options := []HTTPOptionFunc{WithAccessLog(&logs)}
+		if known != nil {
+			options = append(options, WithKnownPools(known))
+		}
+		mux := NewHTTPMux(service, options...)
+		bc, _ := json.Marshal(client)
+		form := url.Values{"poolID": {poolID}, "client": {string(bc)}}
+		req := httptest.NewRequest("POST", "/RequestResource", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	count := func(pool, code string) int64 {
+		rows, err := view.RetrieveData(metrics.HTTPRequestView.Name)
+		Expect(err).NotTo(HaveOccurred())
+		for _, row := range rows {
+			tags := map[tag.Key]string{}
+			for _, t := range row.Tags {
+				tags[t.Key] = t.Value
+			}
+			if tags[metrics.KeyEndpoint] == "/RequestResource" && tags[metrics.KeyPool] == pool && tags[metrics.KeyCode] == code {
+				return row.Data.(*view.CountData).Value
+			}
+		}
+		return 0
+	}
+
+	It("record requests by endpoint, pool and code", func() {
+		service.EXPECT().RequestResource("pool1", client).Return(types.Resource{}, plugin.AcquireLaterErr).Times(2)
+		service.EXPECT().RequestResource("pool1", client).Return(types.Resource{ID: "res1"}, nil)
+		service.EXPECT().RequestResource("random", client).Return(types.Resource{ID: "res1"}, nil)
+
+		Expect(request("pool1")).To(Equal(429))
+		Expect(request("pool1")).To(Equal(429))
+		Expect(request("pool1")).To(Equal(200))
+		Expect(request("random")).To(Equal(200))
+
+		Expect(count("pool1", "429")).To(Equal(int64(2)))
+		Expect(count("pool1", "200")).To(Equal(int64(1)))
+		Expect(count(UnknownPool, "200")).To(Equal(int64(1)))
+		Expect(count("random", "200")).To(BeZero())
+	})
+
+	It("label every pool as unknown without known pools", func() {
+		service.EXPECT().RequestResource("pool1", client).Return(types.Resource{ID: "res1"}, nil)
+		known = nil
+		Expect(request("pool1")).To(Equal(200))
+		Expect(count(UnknownPool, "200")).To(Equal(int64(1)))
+		Expect(count("pool1", "200")).To(BeZero())
+	})
+
+	It("write access logs with the client and the pool", func() {
+		service.EXPECT().RequestResource("pool1", client).Return(types.Resource{ID: "res1"}, nil)
+		service.EXPECT().RequestResource("pool1", client).Return(types.Resource{}, plugin.AcquireLaterErr)
+		request("pool1")
+		request("pool1")
+
+		var entries []AccessLog
+		scanner := bufio.NewScanner(&logs)
+		for scanner.Scan() {
+			var entry AccessLog
+			Expect(json.Unmarshal(scanner.Bytes(), &entry)).NotTo(HaveOccurred())
+			entries = append(entries, entry)
+		}
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].Endpoint).To(Equal("/RequestResource"))
+		Expect(entries[0].Pool).To(Equal("pool1"))
+		Expect(entries[0].Client).To(Equal("client1"))
+		Expect(entries[0].Resource).To(Equal("res1"))
+		Expect(entries[0].Code).To(Equal(200))
+		Expect(entries[0].Time).NotTo(BeZero())
+		Expect(entries[1].Code).To(Equal(429))
+		Expect(entries[1].Resource).To(BeEmpty())
+	})
+})
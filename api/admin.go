@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rueian/godemand/types"
+)
+
+// NewAdminMux exposes the status of plugins and lets operators restart or stop a single plugin by POST.
+func NewAdminMux(p types.Launchpad) *http.ServeMux {
+	mux := &http.ServeMux{}
+	mux.HandleFunc("/GetPluginStatus", func(writer http.ResponseWriter, request *http.Request) {
+		ba, err := json.Marshal(p.Status())
+		if handleErr(writer, err) {
+			return
+		}
+
+		writer.WriteHeader(200)
+		writer.Write(ba)
+	})
+	mux.HandleFunc("/RestartPlugin", func(writer http.ResponseWriter, request *http.Request) {
+		if !allowPost(writer, request) {
+			return
+		}
+		request.ParseForm()
+
+		err := p.Restart(request.Form.Get("name"))
+		if handleErr(writer, err) {
+			return
+		}
+
+		writer.WriteHeader(200)
+	})
+	mux.HandleFunc("/StopPlugin", func(writer http.ResponseWriter, request *http.Request) {
+		if !allowPost(writer, request) {
+			return
+		}
+		request.ParseForm()
+
+		err := p.Stop(request.Form.Get("name"))
+		if handleErr(writer, err) {
+			return
+		}
+
+		writer.WriteHeader(200)
+	})
+	return mux
+}
+
+// allowPost rejects requests other than POST, so that plugins can't be changed by a plain link or a crawler.
+func allowPost(writer http.ResponseWriter, request *http.Request) bool {
+	if request.Method == http.MethodPost {
+		return true
+	}
+	writer.Header().Set("Allow", http.MethodPost)
+	writer.WriteHeader(http.StatusMethodNotAllowed)
+	return false
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/plugin"
+	"github.com/rueian/godemand/types"
+	"github.com/rueian/godemand/types/mock"
+)
+
+var _ = Describe("NewAdminMux", func() {
+	var ctrl *gomock.Controller
+	var launchpad *mock.MockLaunchpad
+	var mux *http.ServeMux
+	var rec *httptest.ResponseRecorder
+	var endpoint string
+	var method string
+	var form url.Values
+
+	BeforeEach(func() {
+		ctrl = gomock.NewController(GinkgoT())
+		launchpad = mock.NewMockLaunchpad(ctrl)
+		mux = NewAdminMux(launchpad)
+		rec = httptest.NewRecorder()
+		form = url.Values{}
+		method = "POST"
+	})
+
+	AfterEach(func() {
+		ctrl.Finish()
+	})
+
+	JustBeforeEach(func() {
+		req := httptest.NewRequest(method, endpoint, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		mux.ServeHTTP(rec, req)
+	})
+
+	Describe("/GetPluginStatus", func() {
+		var status []types.PluginStatus
+
+		BeforeEach(func() {
+			endpoint = "/GetPluginStatus"
+			status = []types.PluginStatus{{Name: "plugin1", Running: true, PID: 1}}
+			launchpad.EXPECT().Status().Return(status)
+		})
+
+		It("got status", func() {
+			var ret []types.PluginStatus
+			Expect(rec.Code).To(Equal(200))
+			Expect(json.Unmarshal(rec.Body.Bytes(), &ret)).NotTo(HaveOccurred())
+			Expect(ret).To(Equal(status))
+		})
+	})
+
+	for _, c := range []struct {
+		Endpoint string
+		Expect   func(*mock.MockLaunchpadMockRecorder) *gomock.Call
+	}{
+		{"/RestartPlugin", func(r *mock.MockLaunchpadMockRecorder) *gomock.Call { return r.Restart("plugin1") }},
+		{"/StopPlugin", func(r *mock.MockLaunchpadMockRecorder) *gomock.Call { return r.Stop("plugin1") }},
+	} {
+		func(endpointName string, expect func(*mock.MockLaunchpadMockRecorder) *gomock.Call) {
+			Describe(endpointName, func() {
+				BeforeEach(func() {
+					endpoint = endpointName
+					form.Add("name", "plugin1")
+				})
+
+				for _, c := range []errorCase{
+					makeErrorCase("no plugin", 404, plugin.PluginNotFoundErr),
+					makeErrorCase("other err", 500, errors.New("random")),
+				} {
+					func(c errorCase) {
+						Context(c.Name, func() {
+							BeforeEach(func() {
+								expect(launchpad.EXPECT()).Return(c.Returns...)
+							})
+							It("err", func() {
+								Expect(rec.Code).To(Equal(c.ExpectCode))
+							})
+						})
+					}(c)
+				}
+
+				Context("success", func() {
+					BeforeEach(func() {
+						expect(launchpad.EXPECT()).Return(nil)
+					})
+					It("ok", func() {
+						Expect(rec.Code).To(Equal(200))
+					})
+				})
+
+				Context("not POST", func() {
+					BeforeEach(func() {
+						method = "GET"
+					})
+					It("reject without calling the launchpad", func() {
+						Expect(rec.Code).To(Equal(405))
+						Expect(rec.Header().Get("Allow")).To(Equal("POST"))
+					})
+				})
+			})
+		}(c.Endpoint, c.Expect)
+	}
+})
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rueian/godemand/metrics"
+	"github.com/rueian/godemand/types"
+)
+
+// NewDebugMux exposes the per resource and per client figures of a pool, which are only aggregated by pool in the metrics.
+// It is opt-in, and should be mounted on an internal listener.
+func NewDebugMux(pool types.ResourceDAO) *http.ServeMux {
+	mux := &http.ServeMux{}
+	mux.HandleFunc("/GetPoolBreakdown", func(writer http.ResponseWriter, request *http.Request) {
+		request.ParseForm()
+
+		p, err := pool.GetResources(request.Form.Get("poolID"))
+		if handleErr(writer, err) {
+			return
+		}
+
+		ba, err := json.Marshal(metrics.Breakdown(p))
+		if handleErr(writer, err) {
+			return
+		}
+
+		writer.WriteHeader(200)
+		writer.Write(ba)
+	})
+	return mux
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/metrics"
+	"github.com/rueian/godemand/resource"
+	"github.com/rueian/godemand/types"
+)
+
+var _ = Describe("NewDebugMux", func() {
+	It("break down the pool by resources and clients", func() {
+		now := time.Now()
+		dao := resource.NewInMemoryResourcePool()
+		dao.SaveResource(types.Resource{ID: "b", PoolID: "pool1", State: types.ResourceServing, StateChange: now.Add(-time.Minute)})
+		dao.SaveResource(types.Resource{ID: "a", PoolID: "pool1"})
+		dao.SaveClient(types.Resource{ID: "b", PoolID: "pool1"}, types.Client{
+			ID:        "c1",
+			CreatedAt: now.Add(-10 * time.Second),
+			Heartbeat: now,
+			Meta:      types.Meta{"requestAt": now.Add(-10 * time.Second), "servedAt": now.Add(-5 * time.Second)},
+		})
+
+		rec := httptest.NewRecorder()
+		NewDebugMux(dao).ServeHTTP(rec, httptest.NewRequest("GET", "/GetPoolBreakdown?poolID=pool1", nil))
+		Expect(rec.Code).To(Equal(200))
+
+		var ret []metrics.ResourceBreakdown
+		Expect(json.Unmarshal(rec.Body.Bytes(), &ret)).NotTo(HaveOccurred())
+		Expect(ret).To(HaveLen(2))
+		Expect(ret[0].ID).To(Equal("a"))
+		Expect(ret[1].State).To(Equal("serving"))
+		Expect(ret[1].Life).To(BeNumerically("~", 60, 1))
+		Expect(ret[1].Clients).To(HaveLen(1))
+		Expect(ret[1].Clients[0].Alive).To(BeTrue())
+		Expect(ret[1].Clients[0].Life).To(BeNumerically("~", 10, 1))
+		Expect(ret[1].Clients[0].Wait).To(BeNumerically("~", 5, 1))
+	})
+})
@@ -6,15 +6,29 @@ import (
 
 	"errors"
 	"github.com/rueian/godemand/plugin"
+	"github.com/rueian/godemand/tracing"
 	"github.com/rueian/godemand/types"
+	"go.opencensus.io/trace"
 )
 
-func NewHTTPMux(s types.Service) *http.ServeMux {
+// NewHTTPMux serves the Service, and records the metrics of requests by endpoint, pool and status code.
+func NewHTTPMux(s types.Service, options ...HTTPOptionFunc) *http.ServeMux {
+	o := &httpOptions{}
+	if svc, ok := s.(*Service); ok && svc.Config != nil {
+		o.known = func(poolID string) bool {
+			_, err := svc.Config.GetPool(poolID)
+			return err == nil
+		}
+	}
+	for _, of := range options {
+		of(o)
+	}
+
 	mux := &http.ServeMux{}
-	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+	mux.HandleFunc("/", o.instrument("/", func(writer http.ResponseWriter, request *http.Request) {
 		writer.Write([]byte("ok"))
-	})
-	mux.HandleFunc("/RequestResource", func(writer http.ResponseWriter, request *http.Request) {
+	}))
+	mux.HandleFunc("/RequestResource", o.instrument("/RequestResource", func(writer http.ResponseWriter, request *http.Request) {
 		request.ParseForm()
 
 		poolID := request.Form.Get("poolID")
@@ -24,14 +38,22 @@ func NewHTTPMux(s types.Service) *http.ServeMux {
 			return
 		}
 
-		res, err := s.RequestResource(poolID, client)
+		ctx, span := trace.StartSpan(request.Context(), tracing.SpanHTTPRequestResource, trace.WithSpanKind(trace.SpanKindServer))
+		var res types.Resource
+		var err error
+		if cs, ok := s.(types.ContextService); ok {
+			res, err = cs.RequestResourceContext(ctx, poolID, client)
+		} else {
+			res, err = s.RequestResource(poolID, client)
+		}
+		tracing.End(span, err)
 		if handleErr(writer, err) {
 			return
 		}
 
 		writeRes(writer, res)
-	})
-	mux.HandleFunc("/GetResource", func(writer http.ResponseWriter, request *http.Request) {
+	}))
+	mux.HandleFunc("/GetResource", o.instrument("/GetResource", func(writer http.ResponseWriter, request *http.Request) {
 		request.ParseForm()
 
 		poolID := request.Form.Get("poolID")
@@ -43,8 +65,8 @@ func NewHTTPMux(s types.Service) *http.ServeMux {
 		}
 
 		writeRes(writer, res)
-	})
-	mux.HandleFunc("/Heartbeat", func(writer http.ResponseWriter, request *http.Request) {
+	}))
+	mux.HandleFunc("/Heartbeat", o.instrument("/Heartbeat", func(writer http.ResponseWriter, request *http.Request) {
 		request.ParseForm()
 
 		poolID := request.Form.Get("poolID")
@@ -60,11 +82,14 @@ func NewHTTPMux(s types.Service) *http.ServeMux {
 		}
 
 		writer.WriteHeader(200)
-	})
+	}))
 	return mux
 }
 
 func writeRes(w http.ResponseWriter, resource types.Resource) {
+	if aw, ok := w.(*accessWriter); ok {
+		aw.resource = resource.ID
+	}
 	ba, err := json.Marshal(resource)
 	if err != nil {
 		handleErr(w, err)
@@ -87,8 +112,10 @@ func handleClient(w http.ResponseWriter, input string) (client types.Client, ok
 func handleErr(w http.ResponseWriter, err error) bool {
 	if errors.Is(err, plugin.AcquireLaterErr) {
 		w.WriteHeader(429)
-	} else if errors.Is(err, types.ResourceNotFoundErr) {
+	} else if errors.Is(err, types.ResourceNotFoundErr) || errors.Is(err, plugin.PluginNotFoundErr) {
 		w.WriteHeader(404)
+	} else if errors.Is(err, types.InvalidResponseErr) {
+		w.WriteHeader(502)
 	} else if err != nil {
 		w.WriteHeader(500)
 	}
@@ -1,11 +1,16 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/rueian/godemand/config"
+	"github.com/rueian/godemand/middleware"
+	"github.com/rueian/godemand/tracing"
 	"github.com/rueian/godemand/types"
+	"go.opencensus.io/trace"
 )
 
 type Service struct {
@@ -13,14 +18,28 @@ type Service struct {
 	Locker    types.Locker
 	Launchpad types.Launchpad
 	Config    *config.Config
+	// Chains decorates the controllers by the middlewares of pools, nil means no middleware.
+	Chains *middleware.Chains
 }
 
 func (s *Service) RequestResource(poolID string, client types.Client) (res types.Resource, err error) {
-	lockID, err := s.Locker.AcquireLock(poolID)
+	return s.RequestResourceContext(context.Background(), poolID, client)
+}
+
+// RequestResourceContext traces the request as the child of the span in the ctx,
+// with the lock acquisition, the dao calls and the controller call as its children.
+func (s *Service) RequestResourceContext(ctx context.Context, poolID string, client types.Client) (res types.Resource, err error) {
+	ctx, span := trace.StartSpan(ctx, tracing.SpanRequestResource)
+	span.AddAttributes(trace.StringAttribute(tracing.AttrPool, poolID), trace.StringAttribute(tracing.AttrClient, client.ID))
+	defer func() { tracing.End(span, err) }()
+
+	locker, dao := tracing.Locker(ctx, s.Locker), tracing.DAO(ctx, s.Pool)
+
+	lockID, err := locker.AcquireLock(poolID)
 	if err != nil {
 		return types.Resource{}, err
 	}
-	defer s.Locker.ReleaseLock(poolID, lockID)
+	defer locker.ReleaseLock(poolID, lockID)
 
 	poolConfig, err := s.Config.GetPool(poolID)
 	if err != nil {
@@ -31,16 +50,39 @@ func (s *Service) RequestResource(poolID string, client types.Client) (res types
 	if err != nil {
 		return types.Resource{}, err
 	}
+	controller = s.Chains.Wrap(poolID, poolConfig.Middleware, controller)
 
-	pool, err := s.Pool.GetResources(poolID)
+	pool, err := dao.GetResources(poolID)
 	if err != nil {
 		return types.Resource{}, err
 	}
 
-	res, err = controller.FindResource(pool, types.Merge(poolConfig.Params, client.PoolConfig))
+	res, err = types.FindResource(ctx, controller, pool, types.Merge(poolConfig.Params, client.PoolConfig))
 	if err != nil {
 		return types.Resource{}, err
 	}
+	if err = types.ValidateFound(pool, res); err != nil {
+		var rerr *types.ResponseError
+		if !errors.As(err, &rerr) {
+			return types.Resource{}, err
+		}
+		if eerr := dao.AppendEvent(types.ResourceEvent{
+			ResourceID:     res.ID,
+			ResourcePoolID: pool.ID,
+			Timestamp:      time.Now(),
+			Meta: types.Meta{
+				"type":   "controller_error",
+				"client": client,
+				"plugin": poolConfig.Plugin,
+				"method": rerr.Method,
+				"field":  rerr.Field,
+				"reason": rerr.Reason,
+			},
+		}); eerr != nil {
+			return types.Resource{}, eerr
+		}
+		return types.Resource{}, err
+	}
 	res.Config = client.PoolConfig
 
 	event := types.ResourceEvent{
@@ -66,10 +108,10 @@ func (s *Service) RequestResource(poolID string, client types.Client) (res types
 	if pool.Resources[res.ID].State != res.State && pool.Resources[res.ID].StateChange == res.StateChange {
 		res.StateChange = time.Now()
 	}
-	if res, err = s.Pool.SaveResource(res); err != nil {
+	if res, err = dao.SaveResource(res); err != nil {
 		return types.Resource{}, err
 	}
-	if err := s.Pool.AppendEvent(event); err != nil {
+	if err := dao.AppendEvent(event); err != nil {
 		return types.Resource{}, err
 	}
 
@@ -1,15 +1,22 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/rueian/godemand/config"
+	"github.com/rueian/godemand/middleware"
 	"github.com/rueian/godemand/plugin"
 	"github.com/rueian/godemand/resource"
+	"github.com/rueian/godemand/tracing"
 	"github.com/rueian/godemand/types"
 	"github.com/rueian/godemand/types/mock"
+	"go.opencensus.io/trace"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -22,6 +29,7 @@ var _ = Describe("Service", func() {
 	var ctrl *gomock.Controller
 	var cfg *config.Config
 	var client types.Client
+	var chains *middleware.Chains
 
 	BeforeEach(func() {
 		ctrl = gomock.NewController(GinkgoT())
@@ -43,6 +51,7 @@ var _ = Describe("Service", func() {
 				},
 			},
 		}
+		chains = nil
 		client = types.Client{
 			ID: "ginkgo",
 			Meta: types.Meta{
@@ -64,6 +73,7 @@ var _ = Describe("Service", func() {
 			Locker:    locker,
 			Config:    cfg,
 			Launchpad: launchpad,
+			Chains:    chains,
 		}
 	})
 
@@ -140,6 +150,44 @@ var _ = Describe("Service", func() {
 				})
 			})
 
+			Context("slow controller with timeout middleware", func() {
+				BeforeEach(func() {
+					pcfg.Middleware.Timeout = 50 * time.Millisecond
+					cfg.Pools[poolID] = pcfg
+					chains = middleware.NewChains()
+					controller.EXPECT().FindResource(p, types.Merge(pcfg.Params, client.PoolConfig)).DoAndReturn(func(types.ResourcePool, map[string]interface{}) (types.Resource, error) {
+						time.Sleep(100 * time.Millisecond)
+						return types.Resource{ID: "a", PoolID: poolID}, nil
+					})
+				})
+				It("got err", func() {
+					Expect(errors.Is(err, middleware.CallTimeoutErr)).To(BeTrue())
+					time.Sleep(100 * time.Millisecond)
+				})
+			})
+
+			Context("invalid resource from controller", func() {
+				BeforeEach(func() {
+					controller.EXPECT().FindResource(p, types.Merge(pcfg.Params, client.PoolConfig)).Return(types.Resource{ID: "a", PoolID: poolID, State: types.ResourceDeleted}, nil)
+				})
+				It("got err", func() {
+					Expect(errors.Is(err, types.InvalidResponseErr)).To(BeTrue())
+				})
+				It("not save the resource", func() {
+					saved, _ := pool.GetResource(poolID, "a")
+					Expect(saved.State).To(Equal(types.ResourcePending))
+				})
+				It("append controller_error events", func() {
+					events, err := pool.GetEventsByPool(poolID, 1, time.Now())
+					Expect(err).NotTo(HaveOccurred())
+					Expect(events).To(HaveLen(1))
+					Expect(events[0].ResourceID).To(Equal("a"))
+					Expect(events[0].Meta).To(HaveKeyWithValue("type", "controller_error"))
+					Expect(events[0].Meta).To(HaveKeyWithValue("field", "State"))
+					Expect(events[0].Meta).To(HaveKeyWithValue("plugin", "plugin1"))
+				})
+			})
+
 			Context("one of resources from controller", func() {
 				BeforeEach(func() {
 					controller.EXPECT().FindResource(p, types.Merge(pcfg.Params, client.PoolConfig)).Return(types.Resource{ID: "a", PoolID: poolID}, nil)
@@ -186,6 +234,79 @@ var _ = Describe("Service", func() {
 				})
 			})
 		})
+		Context("traced request", func() {
+			var dir string
+			var exporter *tracing.Exporter
+
+			BeforeEach(func() {
+				poolID = "pool1"
+				dir, _ = ioutil.TempDir("", "tracing")
+				exporter, _ = tracing.NewFileExporter(filepath.Join(dir, "spans.json"))
+				trace.RegisterExporter(exporter)
+				trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
+				launchpad.EXPECT().GetController("plugin1").Return(controller, nil)
+				controller.EXPECT().FindResource(gomock.Any(), gomock.Any()).Return(types.Resource{ID: "a", PoolID: poolID}, nil)
+			})
+
+			AfterEach(func() {
+				trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(1e-4)})
+				trace.UnregisterExporter(exporter)
+				exporter.Close()
+				os.RemoveAll(dir)
+			})
+
+			It("trace the lock and the dao calls as children of the request", func() {
+				Expect(err).NotTo(HaveOccurred())
+				records, err := tracing.ReadFile(filepath.Join(dir, "spans.json"))
+				Expect(err).NotTo(HaveOccurred())
+
+				var names []string
+				for _, r := range records {
+					names = append(names, r.Name)
+				}
+				Expect(names).To(Equal([]string{
+					tracing.SpanAcquireLock,
+					tracing.SpanDAO + "GetResources",
+					tracing.SpanDAO + "SaveResource",
+					tracing.SpanDAO + "AppendEvent",
+					tracing.SpanReleaseLock,
+					tracing.SpanRequestResource,
+				}))
+				root := records[len(records)-1]
+				Expect(root.Attributes).To(HaveKeyWithValue(tracing.AttrPool, poolID))
+				for _, r := range records[:len(records)-1] {
+					Expect(r.ParentID).To(Equal(root.SpanID))
+				}
+			})
+		})
+		Context("replayed controller", func() {
+			var dir string
+
+			BeforeEach(func() {
+				poolID = "pool1"
+				dir, _ = ioutil.TempDir("", "replay")
+				recording, _ := json.Marshal(middleware.Recording{
+					Method: middleware.FindResource,
+					Pool:   &types.ResourcePool{ID: poolID},
+					Params: types.Merge(cfg.Pools[poolID].Params, client.PoolConfig),
+					Result: types.Resource{ID: "replayed", State: types.ResourceBooting},
+				})
+				Expect(ioutil.WriteFile(filepath.Join(dir, "calls.json"), recording, 0644)).NotTo(HaveOccurred())
+				replayer, err := middleware.NewReplayer(filepath.Join(dir, "calls.json"))
+				Expect(err).NotTo(HaveOccurred())
+				launchpad.EXPECT().GetController("plugin1").Return(replayer, nil)
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(dir)
+			})
+
+			It("save the recorded resource", func() {
+				Expect(err).NotTo(HaveOccurred())
+				saved, _ := pool.GetResource(poolID, "replayed")
+				Expect(saved.State).To(Equal(types.ResourceBooting))
+			})
+		})
 	})
 
 	Describe("GetResource", func() {
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var UsageErr = errors.New("usage: godemand plugin exec <path> find|sync [--params json] [--pool json] [--resource json]")
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	if len(args) >= 2 && args[0] == "plugin" && args[1] == "exec" {
+		return pluginExec(args[2:], stdout)
+	}
+	return UsageErr
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/rueian/godemand/plugin/plugintest"
+	"github.com/rueian/godemand/types"
+)
+
+// pluginExec launches the plugin at the path, calls the method once, and prints the result in json.
+// The json flags can be given as "@file" to be read from the file.
+func pluginExec(args []string, stdout io.Writer) error {
+	if len(args) < 2 {
+		return UsageErr
+	}
+	path, method := args[0], args[1]
+
+	flags := flag.NewFlagSet("plugin exec", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	params := flags.String("params", "{}", "params passed to the plugin in json")
+	pool := flags.String("pool", `{"ID":"cli"}`, "resource pool passed to find in json")
+	resource := flags.String("resource", "", "resource passed to sync in json")
+	kind := flags.String("kind", "", "kind of the plugin")
+	timeout := flags.Duration("timeout", 0, "launch timeout of the plugin")
+	if err := flags.Parse(args[2:]); err != nil {
+		return fmt.Errorf("%v\n%w", err, UsageErr)
+	}
+
+	switch {
+	case method != "find" && method != "sync":
+		return fmt.Errorf("unknown method %q\n%w", method, UsageErr)
+	case method == "sync" && *resource == "":
+		return fmt.Errorf("--resource is required by sync\n%w", UsageErr)
+	}
+	param := types.CmdParam{Path: path, Kind: *kind, LaunchTimeout: *timeout}
+
+	var p map[string]interface{}
+	if err := decode(*params, &p); err != nil {
+		return fmt.Errorf("fail to parse --params: %w", err)
+	}
+
+	h, err := plugintest.Launch(param)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	var res types.Resource
+	switch method {
+	case "find":
+		if err := decode(*pool, &h.Pool); err != nil {
+			return fmt.Errorf("fail to parse --pool: %w", err)
+		}
+		if h.Pool.Resources == nil {
+			h.Pool.Resources = make(map[string]types.Resource)
+		}
+		res, err = h.Find(p)
+	case "sync":
+		if err := decode(*resource, &res); err != nil {
+			return fmt.Errorf("fail to parse --resource: %w", err)
+		}
+		if res.StateChange.IsZero() {
+			res.StateChange = time.Now()
+		}
+		h.Pool.Resources[res.ID] = res
+		res, err = h.Sync(res.ID, p)
+	}
+	if err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(stdout, string(out))
+	return err
+}
+
+func decode(value string, v interface{}) error {
+	data := []byte(value)
+	if strings.HasPrefix(value, "@") {
+		var err error
+		if data, err = ioutil.ReadFile(value[1:]); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/plugin/plugintest"
+	"github.com/rueian/godemand/types"
+)
+
+var _ = Describe("plugin exec", func() {
+	var path string
+	var cleanup func()
+	var stdout *bytes.Buffer
+	var res types.Resource
+
+	BeforeEach(func() {
+		var err error
+		path, cleanup, err = plugintest.Build("github.com/rueian/godemand/plugin/mock/server")
+		Expect(err).NotTo(HaveOccurred())
+		stdout = &bytes.Buffer{}
+		res = types.Resource{}
+	})
+
+	AfterEach(func() {
+		cleanup()
+	})
+
+	It("find with the pool", func() {
+		Expect(run([]string{"plugin", "exec", path, "find", "--pool", `{"ID":"pool1","Resources":{"a":{"ID":"a"}}}`}, stdout)).NotTo(HaveOccurred())
+		Expect(json.Unmarshal(stdout.Bytes(), &res)).NotTo(HaveOccurred())
+		Expect(res.ID).To(Equal("a"))
+		Expect(res.PoolID).To(Equal("pool1"))
+	})
+
+	It("sync with params from file", func() {
+		file := filepath.Join(filepath.Dir(path), "params.json")
+		Expect(ioutil.WriteFile(file, []byte(`{"state":2}`), 0644)).NotTo(HaveOccurred())
+		defer os.Remove(file)
+
+		Expect(run([]string{"plugin", "exec", path, "sync", "--resource", `{"ID":"a","PoolID":"pool1"}`, "--params", "@" + file}, stdout)).NotTo(HaveOccurred())
+		Expect(json.Unmarshal(stdout.Bytes(), &res)).NotTo(HaveOccurred())
+		Expect(res.State).To(Equal(types.ResourceServing))
+	})
+
+	It("return the error of the plugin", func() {
+		err := run([]string{"plugin", "exec", path, "find", "--params", `{"err":"boom"}`}, stdout)
+		Expect(err).To(MatchError(ContainSubstring("boom")))
+	})
+
+	It("print usage for bad args", func() {
+		Expect(errors.Is(run([]string{"plugin"}, stdout), UsageErr)).To(BeTrue())
+		Expect(errors.Is(run([]string{"plugin", "exec", path, "delete"}, stdout), UsageErr)).To(BeTrue())
+		Expect(errors.Is(run([]string{"plugin", "exec", path, "sync"}, stdout), UsageErr)).To(BeTrue())
+		Expect(errors.Is(run([]string{"plugin", "exec", path, "find", "--unknown"}, stdout), UsageErr)).To(BeTrue())
+	})
+})
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
+	"time"
 
 	"github.com/rueian/godemand/types"
 	"gopkg.in/yaml.v2"
@@ -12,33 +14,191 @@ import (
 
 var PoolConfigNotFoundErr = errors.New("pool not found in config")
 
+// Orphan policies decide what to do with resources reported by the controller's ListExternal but unknown to godemand.
+const (
+	OrphanIgnore   = "ignore"
+	OrphanAdopt    = "adopt"
+	OrphanTeardown = "teardown"
+)
+
 type Config struct {
 	Plugins map[string]PluginConfig `yaml:"plugins"`
 	Pools   map[string]PoolConfig   `yaml:"pools"`
 }
 
 type PluginConfig struct {
-	Path string   `yaml:"path"`
-	Envs []string `yaml:"envs"`
+	Path          string         `yaml:"path"`
+	Args          []string       `yaml:"args"`
+	Dir           string         `yaml:"dir"`
+	Envs          []string       `yaml:"envs"`
+	InheritEnv    *bool          `yaml:"inherit_env"`
+	EnvAllowlist  []string       `yaml:"env_allowlist"`
+	SHA256        string         `yaml:"sha256"`
+	Owners        []string       `yaml:"owners"`
+	Rlimits       RlimitsConfig  `yaml:"rlimits"`
+	UID           *uint32        `yaml:"uid"`
+	GID           *uint32        `yaml:"gid"`
+	LaunchTimeout time.Duration  `yaml:"launch_timeout"`
+	Logs          LogsConfig     `yaml:"logs"`
+	Instances     int            `yaml:"instances"`
+	Balance       string         `yaml:"balance"`
+	Kind          string         `yaml:"kind"`
+	Webhook       WebhookConfig  `yaml:"webhook"`
+	Exec          ExecConfig     `yaml:"exec"`
+	Wasm          WasmConfig     `yaml:"wasm"`
+	Starlark      StarlarkConfig `yaml:"starlark"`
+	Encoding      string         `yaml:"encoding"`
+	PoolView      string         `yaml:"pool_view"`
+}
+
+type ExecConfig struct {
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type WasmConfig struct {
+	MemoryLimit  uint64        `yaml:"memory_limit"`
+	Timeout      time.Duration `yaml:"timeout"`
+	AllowedHosts []string      `yaml:"allowed_hosts"`
+}
+
+type StarlarkConfig struct {
+	Source   string        `yaml:"source"`
+	MaxSteps uint64        `yaml:"max_steps"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+type WebhookConfig struct {
+	URL           string            `yaml:"url"`
+	Timeout       time.Duration     `yaml:"timeout"`
+	Retries       int               `yaml:"retries"`
+	RetryInterval time.Duration     `yaml:"retry_interval"`
+	Secret        string            `yaml:"secret"`
+	Headers       map[string]string `yaml:"headers"`
+}
+
+type LogsConfig struct {
+	Buffer   int    `yaml:"buffer"`
+	File     string `yaml:"file"`
+	MaxSize  int64  `yaml:"max_size"`
+	MaxFiles int    `yaml:"max_files"`
+	JSON     bool   `yaml:"json"`
+}
+
+type RlimitsConfig struct {
+	Memory uint64 `yaml:"memory"`
+	CPU    uint64 `yaml:"cpu"`
+	NoFile uint64 `yaml:"nofile"`
 }
 
 type PoolConfig struct {
-	Plugin string                 `yaml:"plugin"`
-	Params map[string]interface{} `yaml:"params"`
+	Plugin     string                 `yaml:"plugin"`
+	Params     map[string]interface{} `yaml:"params"`
+	Orphan     string                 `yaml:"orphan"`
+	Middleware MiddlewareConfig       `yaml:"middleware"`
+}
+
+// MiddlewareConfig of the controller calls of a pool, where zero values disable the corresponding middleware.
+type MiddlewareConfig struct {
+	Timeout     time.Duration     `yaml:"timeout"`
+	Retry       RetryConfig       `yaml:"retry"`
+	Breaker     BreakerConfig     `yaml:"breaker"`
+	Concurrency ConcurrencyConfig `yaml:"concurrency"`
+}
+
+type RetryConfig struct {
+	Attempts    int           `yaml:"attempts"`
+	Interval    time.Duration `yaml:"interval"`
+	MaxInterval time.Duration `yaml:"max_interval"`
+}
+
+type BreakerConfig struct {
+	Failures int           `yaml:"failures"`
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+type ConcurrencyConfig struct {
+	Limit int           `yaml:"limit"`
+	Wait  time.Duration `yaml:"wait"`
 }
 
 func (c *Config) GetPluginCmd() map[string]types.CmdParam {
 	ret := make(map[string]types.CmdParam)
 	for k, v := range c.Plugins {
-		ret[k] = types.CmdParam{
-			Name: k,
-			Path: v.Path,
-			Envs: v.Envs,
+		param := types.CmdParam{
+			Name:         k,
+			Path:         v.Path,
+			Args:         v.Args,
+			Dir:          v.Dir,
+			Envs:         v.Envs,
+			Pools:        c.pluginPools(k),
+			SHA256:       v.SHA256,
+			Owners:       v.Owners,
+			CleanEnv:     v.InheritEnv != nil && !*v.InheritEnv,
+			EnvAllowlist: v.EnvAllowlist,
+			Rlimits: types.Rlimits{
+				Memory: v.Rlimits.Memory,
+				CPU:    v.Rlimits.CPU,
+				NoFile: v.Rlimits.NoFile,
+			},
+			LaunchTimeout: v.LaunchTimeout,
+			Logs: types.LogConfig{
+				Buffer:   v.Logs.Buffer,
+				File:     v.Logs.File,
+				MaxSize:  v.Logs.MaxSize,
+				MaxFiles: v.Logs.MaxFiles,
+				JSON:     v.Logs.JSON,
+			},
+			Instances: v.Instances,
+			Balance:   v.Balance,
+			Kind:      v.Kind,
+			Webhook: types.WebhookConfig{
+				URL:           v.Webhook.URL,
+				Timeout:       v.Webhook.Timeout,
+				Retries:       v.Webhook.Retries,
+				RetryInterval: v.Webhook.RetryInterval,
+				Secret:        v.Webhook.Secret,
+				Headers:       v.Webhook.Headers,
+			},
+			Exec: types.ExecConfig{
+				Timeout: v.Exec.Timeout,
+			},
+			Wasm: types.WasmConfig{
+				MemoryLimit:  v.Wasm.MemoryLimit,
+				Timeout:      v.Wasm.Timeout,
+				AllowedHosts: v.Wasm.AllowedHosts,
+			},
+			Starlark: types.StarlarkConfig{
+				Source:   v.Starlark.Source,
+				MaxSteps: v.Starlark.MaxSteps,
+				Timeout:  v.Starlark.Timeout,
+			},
+			Encoding: v.Encoding,
+			PoolView: v.PoolView,
+		}
+		if v.UID != nil || v.GID != nil {
+			param.Credential = &types.Credential{UID: uint32(os.Getuid()), GID: uint32(os.Getgid())}
+			if v.UID != nil {
+				param.Credential.UID = *v.UID
+			}
+			if v.GID != nil {
+				param.Credential.GID = *v.GID
+			}
 		}
+		ret[k] = param
 	}
 	return ret
 }
 
+func (c *Config) pluginPools(plugin string) (pools []string) {
+	for id, pool := range c.Pools {
+		if pool.Plugin == plugin {
+			pools = append(pools, id)
+		}
+	}
+	sort.Strings(pools)
+	return pools
+}
+
 func (c *Config) GetPool(poolID string) (pool PoolConfig, err error) {
 	if pool, ok := c.Pools[poolID]; ok {
 		return pool, nil
@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	"errors"
 	. "github.com/onsi/ginkgo"
@@ -33,22 +34,128 @@ plugins:
      envs:
      - A=B
      - C=D
+     sha256: abcd
+     owners:
+     - root
+     args:
+     - -v
+     dir: /tmp
+     inherit_env: false
+     env_allowlist:
+     - PATH
+     rlimits:
+       nofile: 1024
+     uid: 1000
+     launch_timeout: 10s
+     logs:
+       buffer: 50
+       file: /var/log/plugin1.log
+       max_size: 1048576
+       max_files: 3
+       json: true
+     instances: 2
+     balance: pool
+     encoding: msgpack
+     pool_view: delta
+  plugin2:
+     kind: webhook
+     webhook:
+       url: http://localhost:8080/hooks
+       timeout: 1s
+       retries: 2
+       retry_interval: 100ms
+       secret: shh
+       headers:
+         Authorization: Bearer token
+  plugin3:
+     kind: exec
+     path: /scripts/controller.sh
+     exec:
+       timeout: 5s
+  plugin4:
+     kind: wasm
+     path: /plugins/controller.wasm
+     wasm:
+       memory_limit: 16777216
+       timeout: 1s
+       allowed_hosts:
+       - api.example.com
+  plugin5:
+     kind: starlark
+     starlark:
+       max_steps: 1000
+       timeout: 1s
+       source: |
+         def find_resource(pool, params):
+             return {"ID": "a"}
 pools:
   pool1:
     plugin: plugin1
     params:
       str: something
       int: 1234
+    middleware:
+      timeout: 5s
+      retry:
+        attempts: 3
+        interval: 100ms
+        max_interval: 1s
+      breaker:
+        failures: 5
+        cooldown: 30s
+      concurrency:
+        limit: 10
+        wait: 1s
 `)
 		})
 
 		It("parsed", func() {
+			inherit, uid := false, uint32(1000)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(*config).To(Equal(Config{
 				Plugins: map[string]PluginConfig{
 					"plugin1": {
-						Path: "/something",
-						Envs: []string{"A=B", "C=D"},
+						Path:          "/something",
+						Envs:          []string{"A=B", "C=D"},
+						SHA256:        "abcd",
+						Owners:        []string{"root"},
+						Args:          []string{"-v"},
+						Dir:           "/tmp",
+						InheritEnv:    &inherit,
+						EnvAllowlist:  []string{"PATH"},
+						Rlimits:       RlimitsConfig{NoFile: 1024},
+						UID:           &uid,
+						LaunchTimeout: 10 * time.Second,
+						Logs:          LogsConfig{Buffer: 50, File: "/var/log/plugin1.log", MaxSize: 1048576, MaxFiles: 3, JSON: true},
+						Instances:     2,
+						Balance:       "pool",
+						Encoding:      "msgpack",
+						PoolView:      "delta",
+					},
+					"plugin2": {
+						Kind: "webhook",
+						Webhook: WebhookConfig{
+							URL:           "http://localhost:8080/hooks",
+							Timeout:       time.Second,
+							Retries:       2,
+							RetryInterval: 100 * time.Millisecond,
+							Secret:        "shh",
+							Headers:       map[string]string{"Authorization": "Bearer token"},
+						},
+					},
+					"plugin3": {
+						Kind: "exec",
+						Path: "/scripts/controller.sh",
+						Exec: ExecConfig{Timeout: 5 * time.Second},
+					},
+					"plugin4": {
+						Kind: "wasm",
+						Path: "/plugins/controller.wasm",
+						Wasm: WasmConfig{MemoryLimit: 16777216, Timeout: time.Second, AllowedHosts: []string{"api.example.com"}},
+					},
+					"plugin5": {
+						Kind:     "starlark",
+						Starlark: StarlarkConfig{Source: starlarkSource, MaxSteps: 1000, Timeout: time.Second},
 					},
 				},
 				Pools: map[string]PoolConfig{
@@ -58,6 +165,12 @@ pools:
 							"str": "something",
 							"int": 1234,
 						},
+						Middleware: MiddlewareConfig{
+							Timeout:     5 * time.Second,
+							Retry:       RetryConfig{Attempts: 3, Interval: 100 * time.Millisecond, MaxInterval: time.Second},
+							Breaker:     BreakerConfig{Failures: 5, Cooldown: 30 * time.Second},
+							Concurrency: ConcurrencyConfig{Limit: 10, Wait: time.Second},
+						},
 					},
 				},
 			}))
@@ -94,9 +207,53 @@ pools:
 			It("turn config into map of CmdParam", func() {
 				Expect(config.GetPluginCmd()).To(Equal(map[string]types.CmdParam{
 					"plugin1": {
-						Name: "plugin1",
-						Path: "/something",
-						Envs: []string{"A=B", "C=D"},
+						Name:          "plugin1",
+						Path:          "/something",
+						Envs:          []string{"A=B", "C=D"},
+						Pools:         []string{"pool1"},
+						SHA256:        "abcd",
+						Owners:        []string{"root"},
+						Args:          []string{"-v"},
+						Dir:           "/tmp",
+						CleanEnv:      true,
+						EnvAllowlist:  []string{"PATH"},
+						Rlimits:       types.Rlimits{NoFile: 1024},
+						Credential:    &types.Credential{UID: 1000, GID: uint32(os.Getgid())},
+						LaunchTimeout: 10 * time.Second,
+						Logs:          types.LogConfig{Buffer: 50, File: "/var/log/plugin1.log", MaxSize: 1048576, MaxFiles: 3, JSON: true},
+						Instances:     2,
+						Balance:       types.BalancePool,
+						Encoding:      types.EncodingMsgpack,
+						PoolView:      types.PoolViewDelta,
+					},
+					"plugin2": {
+						Name: "plugin2",
+						Kind: types.KindWebhook,
+						Webhook: types.WebhookConfig{
+							URL:           "http://localhost:8080/hooks",
+							Timeout:       time.Second,
+							Retries:       2,
+							RetryInterval: 100 * time.Millisecond,
+							Secret:        "shh",
+							Headers:       map[string]string{"Authorization": "Bearer token"},
+						},
+					},
+					"plugin3": {
+						Name: "plugin3",
+						Kind: types.KindExec,
+						Path: "/scripts/controller.sh",
+						Exec: types.ExecConfig{Timeout: 5 * time.Second},
+					},
+					"plugin4": {
+						Name: "plugin4",
+						Kind: types.KindWasm,
+						Path: "/plugins/controller.wasm",
+						Wasm: types.WasmConfig{MemoryLimit: 16777216, Timeout: time.Second, AllowedHosts: []string{"api.example.com"}},
+					},
+					"plugin5": {
+						Name:     "plugin5",
+						Kind:     types.KindStarlark,
+						Starlark: types.StarlarkConfig{Source: starlarkSource, MaxSteps: 1000, Timeout: time.Second},
 					},
 				}))
 			})
@@ -137,3 +294,7 @@ func TestConfig(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Config Suite")
 }
+
+const starlarkSource = `def find_resource(pool, params):
+    return {"ID": "a"}
+`
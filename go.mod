@@ -1,15 +1,44 @@
 module github.com/rueian/godemand
 
-go 1.14
+go 1.20
 
 require (
+	contrib.go.opencensus.io/exporter/prometheus v0.2.0
 	github.com/go-redis/redis v6.15.7+incompatible
 	github.com/golang/mock v1.3.1
-	github.com/kr/pretty v0.2.0 // indirect
 	github.com/onsi/ginkgo v1.8.0
 	github.com/onsi/gomega v1.5.0
+	github.com/prometheus/client_golang v1.2.1
+	github.com/tetratelabs/wazero v1.6.0
+	github.com/vmihailenco/msgpack/v5 v5.3.5
 	go.opencensus.io v0.22.3
-	golang.org/x/sys v0.1.0 // indirect
-	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
+	go.starlark.net v0.0.0-20230302034142-4b1e35fe2254
+	golang.org/x/sys v0.1.0
 	gopkg.in/yaml.v2 v2.2.8
 )
+
+require (
+	github.com/alecthomas/template v0.0.0-20190718012654-fb15b899a751 // indirect
+	github.com/alecthomas/units v0.0.0-20190717042225-c3de453c63f4 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.1.1 // indirect
+	github.com/golang/groupcache v0.0.0-20191027212112-611e8accdfc9 // indirect
+	github.com/golang/protobuf v1.4.1 // indirect
+	github.com/hashicorp/golang-lru v0.5.1 // indirect
+	github.com/hpcloud/tail v1.0.0 // indirect
+	github.com/konsorten/go-windows-terminal-sequences v1.0.1 // indirect
+	github.com/kr/pretty v0.2.0 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/prometheus/client_model v0.0.0-20190812154241-14fe0d1b01d4 // indirect
+	github.com/prometheus/common v0.7.0 // indirect
+	github.com/prometheus/procfs v0.0.6 // indirect
+	github.com/prometheus/statsd_exporter v0.15.0 // indirect
+	github.com/sirupsen/logrus v1.4.2 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	golang.org/x/net v0.0.0-20190620200207-3b0461eec859 // indirect
+	golang.org/x/text v0.3.2 // indirect
+	google.golang.org/protobuf v1.25.0 // indirect
+	gopkg.in/alecthomas/kingpin.v2 v2.2.6 // indirect
+	gopkg.in/fsnotify.v1 v1.4.7 // indirect
+	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
+)
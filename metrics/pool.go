@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"sort"
+	"time"
+
+	"github.com/rueian/godemand/types"
+)
+
+// ClientTimeout is how long a client is considered alive since its last heartbeat.
+const ClientTimeout = time.Minute
+
+// RecordPool records the number of resources by state and of alive clients, and samples the time resources
+// have been in their states, the lifetime and the waiting time of alive clients into the distributions of the pool.
+func RecordPool(pool types.ResourcePool) {
+	now := time.Now()
+	counts := make(map[string]int64, len(types.ResourceStates))
+	for _, s := range types.ResourceStates {
+		counts[s.String()] = 0
+	}
+	clients := 0
+	for _, res := range pool.Resources {
+		counts[res.State.String()]++
+		RecordResourceLife(pool.ID, res.State.String(), now.Sub(res.StateChange))
+		for _, c := range res.Clients {
+			if now.Sub(c.Heartbeat) >= ClientTimeout {
+				continue
+			}
+			clients++
+			RecordClientLife(pool.ID, c.Heartbeat.Sub(c.CreatedAt))
+			if wait, ok := clientWait(c, now); ok {
+				RecordClientWait(pool.ID, wait)
+			}
+		}
+	}
+	for state, count := range counts {
+		RecordResourceCount(pool.ID, state, count)
+	}
+	RecordClientCount(pool.ID, int64(clients))
+}
+
+// ResourceBreakdown is the per resource figures which are aggregated by pool in the views.
+type ResourceBreakdown struct {
+	ID    string
+	State string
+	// Life is the seconds the resource has been in its current state.
+	Life    float64
+	Clients []ClientBreakdown
+}
+
+// ClientBreakdown is the per client figures which are aggregated by pool in the views.
+type ClientBreakdown struct {
+	ID    string
+	Alive bool
+	Life  float64
+	// Wait is the seconds from the client requesting to being served, or until now if it is not served yet.
+	Wait float64 `json:",omitempty"`
+}
+
+// Breakdown computes the figures of each resource and client of the pool, sorted by ids.
+// It is computed on demand for debugging, instead of being recorded into views tagged by ids.
+func Breakdown(pool types.ResourcePool) []ResourceBreakdown {
+	now := time.Now()
+	ret := make([]ResourceBreakdown, 0, len(pool.Resources))
+	for _, res := range pool.Resources {
+		rb := ResourceBreakdown{ID: res.ID, State: res.State.String(), Life: now.Sub(res.StateChange).Seconds()}
+		for _, c := range res.Clients {
+			cb := ClientBreakdown{ID: c.ID, Alive: now.Sub(c.Heartbeat) < ClientTimeout, Life: c.Heartbeat.Sub(c.CreatedAt).Seconds()}
+			if wait, ok := clientWait(c, now); ok {
+				cb.Wait = wait.Seconds()
+			}
+			rb.Clients = append(rb.Clients, cb)
+		}
+		sort.Slice(rb.Clients, func(i, j int) bool {
+			return rb.Clients[i].ID < rb.Clients[j].ID
+		})
+		ret = append(ret, rb)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID < ret[j].ID
+	})
+	return ret
+}
+
+// clientWait is the time from the requestAt to the servedAt reported by the client in its meta.
+func clientWait(c types.Client, now time.Time) (time.Duration, bool) {
+	rt, ok := c.Meta["requestAt"]
+	if !ok {
+		return 0, false
+	}
+	served := now
+	if st, ok := c.Meta["servedAt"]; ok {
+		served = toTime(st)
+	}
+	return served.Sub(toTime(rt)), true
+}
+
+func toTime(t interface{}) time.Time {
+	switch v := t.(type) {
+	case time.Time:
+		return v
+	case string:
+		ts, _ := time.Parse(time.RFC3339, v)
+		return ts
+	}
+
+	return time.Time{}
+}
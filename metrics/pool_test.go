@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/types"
+	"go.opencensus.io/stats/view"
+)
+
+var _ = Describe("RecordPool", func() {
+	BeforeEach(func() {
+		Expect(view.Register(ResourceCountView, ResourceLifeView, ClientWaitView)).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		view.Unregister(ResourceCountView, ResourceLifeView, ClientWaitView)
+	})
+
+	It("record distributions by pool without ids", func() {
+		now := time.Now()
+		pool := types.ResourcePool{ID: "pool1", Resources: map[string]types.Resource{}}
+		for _, id := range []string{"a", "b", "c"} {
+			pool.Resources[id] = types.Resource{ID: id, State: types.ResourceServing, StateChange: now.Add(-2 * time.Second), Clients: map[string]types.Client{
+				id: {ID: id, Heartbeat: now, Meta: types.Meta{"requestAt": now.Add(-20 * time.Second), "servedAt": now.Add(-18 * time.Second)}},
+			}}
+		}
+		pool.Resources["d"] = types.Resource{ID: "d", State: types.ResourceBooting, Clients: map[string]types.Client{
+			"gone": {ID: "gone", Heartbeat: now.Add(-2 * ClientTimeout), Meta: types.Meta{"requestAt": now}},
+		}}
+		RecordPool(pool)
+
+		rows, err := view.RetrieveData(ResourceLifeView.Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rows).To(HaveLen(2))
+		for _, row := range rows {
+			if row.Tags[1].Value == "serving" {
+				Expect(row.Data.(*view.DistributionData).Count).To(Equal(int64(3)))
+				Expect(row.Data.(*view.DistributionData).CountPerBucket[1]).To(Equal(int64(3)))
+			}
+		}
+
+		rows, err = view.RetrieveData(ClientWaitView.Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rows).To(HaveLen(1))
+		Expect(rows[0].Data.(*view.DistributionData).Count).To(Equal(int64(3)))
+		Expect(rows[0].Data.(*view.DistributionData).Mean).To(BeNumerically("~", 2, 0.1))
+
+		rows, err = view.RetrieveData(ResourceCountView.Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rows).To(HaveLen(len(types.ResourceStates)))
+	})
+})
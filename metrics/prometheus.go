@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"net/http"
+
+	ocprom "contrib.go.opencensus.io/exporter/prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type prometheusOptions struct {
+	runtime bool
+}
+
+type PrometheusOptionFunc func(o *prometheusOptions)
+
+// WithRuntimeMetrics also exposes the go runtime metrics, such as goroutines and gc pauses,
+// and the process metrics, such as cpu, memory and open fds.
+func WithRuntimeMetrics() PrometheusOptionFunc {
+	return func(o *prometheusOptions) {
+		o.runtime = true
+	}
+}
+
+// NewPrometheusHandler serves every registered view in the prometheus text format on each scrape,
+// where the tag keys become the label names. Count views are exposed as counters, LastValue views as gauges,
+// and Distribution views as histograms. It can be mounted on the api mux or the admin mux as the /metrics.
+func NewPrometheusHandler(options ...PrometheusOptionFunc) (http.Handler, error) {
+	o := &prometheusOptions{}
+	for _, of := range options {
+		of(o)
+	}
+
+	registry := prometheus.NewRegistry()
+	if o.runtime {
+		if err := registry.Register(prometheus.NewGoCollector()); err != nil {
+			return nil, err
+		}
+		if err := registry.Register(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{})); err != nil {
+			return nil, err
+		}
+	}
+	return ocprom.NewExporter(ocprom.Options{Registry: registry})
+}
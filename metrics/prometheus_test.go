@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opencensus.io/stats/view"
+)
+
+var _ = Describe("NewPrometheusHandler", func() {
+	BeforeEach(func() {
+		Expect(view.Register(PluginLatencyView, PluginCallView, PluginUpView)).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		view.Unregister(PluginLatencyView, PluginCallView, PluginUpView)
+	})
+
+	scrape := func(options ...PrometheusOptionFunc) string {
+		handler, err := NewPrometheusHandler(options...)
+		Expect(err).NotTo(HaveOccurred())
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		Expect(w.Code).To(Equal(200))
+		body, _ := ioutil.ReadAll(w.Body)
+		return string(body)
+	}
+
+	It("expose registered views with their types and labels", func() {
+		RecordPluginCall("p1", "pool1", "FindResource", OutcomeOK, 20*time.Millisecond)
+		RecordPluginUp("p1", true)
+		// make sure the records are processed
+		_, err := view.RetrieveData(PluginUpView.Name)
+		Expect(err).NotTo(HaveOccurred())
+
+		body := scrape()
+		Expect(body).To(ContainSubstring("# TYPE godemand_plugin_calls counter"))
+		Expect(body).To(ContainSubstring(`godemand_plugin_calls{method="FindResource",outcome="ok",plugin="p1",pool="pool1"} 1`))
+		Expect(body).To(ContainSubstring("# TYPE godemand_plugin_latency histogram"))
+		Expect(body).To(ContainSubstring(`godemand_plugin_latency_bucket{method="FindResource",outcome="ok",plugin="p1",pool="pool1",le="25"} 1`))
+		Expect(body).To(ContainSubstring("# TYPE godemand_plugin_up gauge"))
+		Expect(body).To(ContainSubstring(`godemand_plugin_up{plugin="p1"} 1`))
+		Expect(body).NotTo(ContainSubstring("go_goroutines"))
+	})
+
+	It("expose runtime and process metrics with the option", func() {
+		body := scrape(WithRuntimeMetrics())
+		Expect(body).To(ContainSubstring("go_goroutines"))
+		Expect(body).To(ContainSubstring("process_resident_memory_bytes"))
+	})
+})
@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"context"
+	"strconv"
 	"time"
 
 	"go.opencensus.io/stats"
@@ -10,16 +11,37 @@ import (
 )
 
 var (
-	MResourceCount = stats.Int64("godemand/resource/count", "The current number of resources", "1")
-	MResourceLife  = stats.Float64("godemand/resource/life", "The lifetime of resources", "s")
-	MClientCount   = stats.Int64("godemand/client/count", "The current number of clients", "1")
-	MClientLife    = stats.Float64("godemand/client/life", "The lifetime of clients", "s")
-	MClientWait    = stats.Float64("godemand/client/wait", "The lifetime of clients", "s")
+	MResourceCount  = stats.Int64("godemand/resource/count", "The current number of resources", "1")
+	MResourceLife   = stats.Float64("godemand/resource/life", "The time resources have been in their current state", "s")
+	MClientCount    = stats.Int64("godemand/client/count", "The current number of clients", "1")
+	MClientLife     = stats.Float64("godemand/client/life", "The lifetime of clients", "s")
+	MClientWait     = stats.Float64("godemand/client/wait", "The waiting time of clients", "s")
+	MBreakerState   = stats.Int64("godemand/breaker/state", "The circuit breaker state of pools, 0 closed, 1 half open and 2 open", "1")
+	MBreakerReject  = stats.Int64("godemand/breaker/rejected", "The number of controller calls rejected by open circuit breakers", "1")
+	MPluginLatency  = stats.Float64("godemand/plugin/latency", "The latency of plugin rpc calls", "ms")
+	MPluginError    = stats.Int64("godemand/plugin/errors", "The number of failed plugin rpc calls", "1")
+	MPluginRestart  = stats.Int64("godemand/plugin/restarts", "The number of restarts of plugins", "1")
+	MPluginUp       = stats.Int64("godemand/plugin/up", "Whether plugins have running instances, 1 up and 0 down", "1")
+	MHTTPLatency    = stats.Float64("godemand/http/latency", "The latency of http api requests", "ms")
+	MSyncSweep      = stats.Float64("godemand/syncer/sweep", "The duration of sweeping a pool, from listing its resources until all of them are synced", "ms")
+	MSyncQueue      = stats.Int64("godemand/syncer/queue", "The number of resources waiting in the sync queue", "1")
+	MSyncLag        = stats.Float64("godemand/syncer/lag", "The time since resources were last synced", "s")
+	MSyncError      = stats.Int64("godemand/syncer/errors", "The number of failed syncs", "1")
+	MSyncTransition = stats.Int64("godemand/syncer/transitions", "The number of state transitions made by syncs", "1")
 
 	KeyPool, _     = tag.NewKey("pool")
 	KeyState, _    = tag.NewKey("state")
-	KeyResource, _ = tag.NewKey("resource")
-	KeyClient, _   = tag.NewKey("client")
+	KeyPlugin, _   = tag.NewKey("plugin")
+	KeyMethod, _   = tag.NewKey("method")
+	KeyOutcome, _  = tag.NewKey("outcome")
+	KeyEndpoint, _ = tag.NewKey("endpoint")
+	KeyCode, _     = tag.NewKey("code")
+	KeyCause, _    = tag.NewKey("cause")
+
+	// durationBuckets are the bounds in seconds from 1 second to 1 day.
+	durationBuckets = view.Distribution(1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600, 7200, 21600, 86400)
+	// latencyBuckets are the bounds in milliseconds from 1 millisecond to 30 seconds.
+	latencyBuckets = view.Distribution(1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000)
 
 	ResourceCountView = &view.View{
 		Name:        "godemand/resource/count",
@@ -32,9 +54,9 @@ var (
 	ResourceLifeView = &view.View{
 		Name:        "godemand/resource/life",
 		Measure:     MResourceLife,
-		Description: "The lifetime of resources",
-		Aggregation: view.LastValue(),
-		TagKeys:     []tag.Key{KeyPool, KeyResource, KeyState},
+		Description: "The distribution of the time resources have been in their current state, sampled on each sweep",
+		Aggregation: durationBuckets,
+		TagKeys:     []tag.Key{KeyPool, KeyState},
 	}
 
 	ClientCountView = &view.View{
@@ -48,34 +70,172 @@ var (
 	ClientLifeView = &view.View{
 		Name:        "godemand/client/life",
 		Measure:     MClientLife,
-		Description: "The lifetime of clients",
-		Aggregation: view.LastValue(),
-		TagKeys:     []tag.Key{KeyPool, KeyClient},
+		Description: "The distribution of the lifetime of alive clients, sampled on each sweep",
+		Aggregation: durationBuckets,
+		TagKeys:     []tag.Key{KeyPool},
 	}
 
 	ClientWaitView = &view.View{
 		Name:        "godemand/client/wait",
 		Measure:     MClientWait,
-		Description: "The waiting time of clients",
+		Description: "The distribution of the waiting time of alive clients, sampled on each sweep",
+		Aggregation: durationBuckets,
+		TagKeys:     []tag.Key{KeyPool},
+	}
+
+	BreakerStateView = &view.View{
+		Name:        "godemand/breaker/state",
+		Measure:     MBreakerState,
+		Description: "The circuit breaker state of pools, 0 closed, 1 half open and 2 open",
 		Aggregation: view.LastValue(),
-		TagKeys:     []tag.Key{KeyPool, KeyClient},
+		TagKeys:     []tag.Key{KeyPool},
 	}
+
+	BreakerRejectView = &view.View{
+		Name:        "godemand/breaker/rejected",
+		Measure:     MBreakerReject,
+		Description: "The number of controller calls rejected by open circuit breakers",
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{KeyPool},
+	}
+
+	PluginLatencyView = &view.View{
+		Name:        "godemand/plugin/latency",
+		Measure:     MPluginLatency,
+		Description: "The latency distribution of plugin rpc calls",
+		Aggregation: latencyBuckets,
+		TagKeys:     []tag.Key{KeyPlugin, KeyPool, KeyMethod, KeyOutcome},
+	}
+
+	PluginCallView = &view.View{
+		Name:        "godemand/plugin/calls",
+		Measure:     MPluginLatency,
+		Description: "The number of plugin rpc calls",
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{KeyPlugin, KeyPool, KeyMethod, KeyOutcome},
+	}
+
+	PluginErrorView = &view.View{
+		Name:        "godemand/plugin/errors",
+		Measure:     MPluginError,
+		Description: "The number of failed plugin rpc calls",
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{KeyPlugin, KeyPool, KeyMethod, KeyOutcome},
+	}
+
+	PluginRestartView = &view.View{
+		Name:        "godemand/plugin/restarts",
+		Measure:     MPluginRestart,
+		Description: "The number of restarts of plugins",
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{KeyPlugin},
+	}
+
+	PluginUpView = &view.View{
+		Name:        "godemand/plugin/up",
+		Measure:     MPluginUp,
+		Description: "Whether plugins have running instances, 1 up and 0 down",
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{KeyPlugin},
+	}
+
+	HTTPLatencyView = &view.View{
+		Name:        "godemand/http/latency",
+		Measure:     MHTTPLatency,
+		Description: "The latency distribution of http api requests",
+		Aggregation: latencyBuckets,
+		TagKeys:     []tag.Key{KeyEndpoint, KeyPool, KeyCode},
+	}
+
+	HTTPRequestView = &view.View{
+		Name:        "godemand/http/requests",
+		Measure:     MHTTPLatency,
+		Description: "The number of http api requests",
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{KeyEndpoint, KeyPool, KeyCode},
+	}
+
+	SyncSweepView = &view.View{
+		Name:        "godemand/syncer/sweep",
+		Measure:     MSyncSweep,
+		Description: "The distribution of the duration of sweeping a pool, from listing its resources until all of them are synced",
+		Aggregation: latencyBuckets,
+		TagKeys:     []tag.Key{KeyPool},
+	}
+
+	SyncQueueView = &view.View{
+		Name:        "godemand/syncer/queue",
+		Measure:     MSyncQueue,
+		Description: "The number of resources waiting in the sync queue",
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{KeyPool},
+	}
+
+	SyncLagView = &view.View{
+		Name:        "godemand/syncer/lag",
+		Measure:     MSyncLag,
+		Description: "The distribution of the time since resources were last synced, sampled on each sweep",
+		Aggregation: durationBuckets,
+		TagKeys:     []tag.Key{KeyPool},
+	}
+
+	SyncErrorView = &view.View{
+		Name:        "godemand/syncer/errors",
+		Measure:     MSyncError,
+		Description: "The number of failed syncs by cause",
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{KeyPool, KeyCause},
+	}
+
+	SyncTransitionView = &view.View{
+		Name:        "godemand/syncer/transitions",
+		Measure:     MSyncTransition,
+		Description: "The number of state transitions made by syncs, by the new state",
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{KeyPool, KeyState},
+	}
+)
+
+// Causes of failed syncs.
+const (
+	CauseConfigMissing     = "config_missing"
+	CauseControllerMissing = "controller_missing"
+	CauseLockBusy          = "lock_busy"
+	// CauseLockError is for lock failures other than busy, such as the locker being unreachable.
+	CauseLockError   = "lock_error"
+	CausePluginError = "plugin_error"
+	// CauseInvalidResponse is for resources returned by the plugin rejected by the types.ValidateSynced.
+	CauseInvalidResponse = "invalid_response"
+	CauseDAOError        = "dao_error"
+	// CauseConfigInvalid is for pool configs rejected at runtime, such as an unknown orphan policy.
+	CauseConfigInvalid = "config_invalid"
 )
 
+// Outcomes of plugin rpc calls.
+const (
+	OutcomeOK = "ok"
+	// OutcomeError is for errors returned by the controller of the plugin.
+	OutcomeError = "error"
+	// OutcomeUnavailable is for failures of the rpc itself, such as the plugin exited.
+	OutcomeUnavailable = "unavailable"
+)
+
+// Views are all the views of godemand. Since none of them is tagged by resource or client ids,
+// their cardinality is bounded by the pools, states and plugins.
+var Views = []*view.View{
+	ResourceCountView, ResourceLifeView, ClientCountView, ClientLifeView, ClientWaitView, BreakerStateView, BreakerRejectView,
+	PluginLatencyView, PluginCallView, PluginErrorView, PluginRestartView, PluginUpView,
+	HTTPLatencyView, HTTPRequestView,
+	SyncSweepView, SyncQueueView, SyncLagView, SyncErrorView, SyncTransitionView,
+}
+
+// StartRecording registers the exporters and the Views, which are reported to the exporters by the period.
 func StartRecording(period time.Duration, es ...view.Exporter) error {
 	for _, e := range es {
 		view.RegisterExporter(e)
 	}
 	view.SetReportingPeriod(period)
-
-	for {
-		if err := view.Register(ResourceCountView, ResourceLifeView, ClientCountView, ClientLifeView, ClientWaitView); err != nil {
-			return err
-		}
-		// since we trace each resource and client, it is necessary unregistering views periodically to avoid memory leak.
-		time.Sleep(period * 3)
-		view.Unregister(ResourceCountView, ResourceLifeView, ClientCountView, ClientLifeView, ClientWaitView)
-	}
+	return view.Register(Views...)
 }
 
 func RecordResourceCount(pool, state string, count int64) {
@@ -88,11 +248,10 @@ func RecordResourceCount(pool, state string, count int64) {
 	stats.Record(ctx, MResourceCount.M(count))
 }
 
-func RecordResourceLife(pool, state, resource string, duration time.Duration) {
+func RecordResourceLife(pool, state string, duration time.Duration) {
 	ctx, _ := tag.New(
 		context.Background(),
 		tag.Insert(KeyPool, pool),
-		tag.Insert(KeyResource, resource),
 		tag.Insert(KeyState, state),
 	)
 
@@ -108,22 +267,133 @@ func RecordClientCount(pool string, count int64) {
 	stats.Record(ctx, MClientCount.M(count))
 }
 
-func RecordClientLife(pool, client string, duration time.Duration) {
+func RecordClientLife(pool string, duration time.Duration) {
 	ctx, _ := tag.New(
 		context.Background(),
 		tag.Insert(KeyPool, pool),
-		tag.Insert(KeyClient, client),
 	)
 
 	stats.Record(ctx, MClientLife.M(duration.Seconds()))
 }
 
-func RecordClientWait(pool, client string, duration time.Duration) {
+func RecordClientWait(pool string, duration time.Duration) {
 	ctx, _ := tag.New(
 		context.Background(),
 		tag.Insert(KeyPool, pool),
-		tag.Insert(KeyClient, client),
 	)
 
 	stats.Record(ctx, MClientWait.M(duration.Seconds()))
 }
+
+func RecordBreakerState(pool string, state int64) {
+	ctx, _ := tag.New(
+		context.Background(),
+		tag.Insert(KeyPool, pool),
+	)
+
+	stats.Record(ctx, MBreakerState.M(state))
+}
+
+func RecordBreakerReject(pool string) {
+	ctx, _ := tag.New(
+		context.Background(),
+		tag.Insert(KeyPool, pool),
+	)
+
+	stats.Record(ctx, MBreakerReject.M(1))
+}
+
+func RecordPluginCall(plugin, pool, method, outcome string, latency time.Duration) {
+	ctx, _ := tag.New(
+		context.Background(),
+		tag.Insert(KeyPlugin, plugin),
+		tag.Insert(KeyPool, pool),
+		tag.Insert(KeyMethod, method),
+		tag.Insert(KeyOutcome, outcome),
+	)
+
+	stats.Record(ctx, MPluginLatency.M(float64(latency)/float64(time.Millisecond)))
+	if outcome != OutcomeOK {
+		stats.Record(ctx, MPluginError.M(1))
+	}
+}
+
+func RecordPluginRestarts(plugin string, restarts int64) {
+	ctx, _ := tag.New(
+		context.Background(),
+		tag.Insert(KeyPlugin, plugin),
+	)
+
+	stats.Record(ctx, MPluginRestart.M(restarts))
+}
+
+func RecordPluginUp(plugin string, up bool) {
+	ctx, _ := tag.New(
+		context.Background(),
+		tag.Insert(KeyPlugin, plugin),
+	)
+
+	var v int64
+	if up {
+		v = 1
+	}
+	stats.Record(ctx, MPluginUp.M(v))
+}
+
+func RecordHTTPRequest(endpoint, pool string, code int, latency time.Duration) {
+	ctx, _ := tag.New(
+		context.Background(),
+		tag.Insert(KeyEndpoint, endpoint),
+		tag.Insert(KeyPool, pool),
+		tag.Insert(KeyCode, strconv.Itoa(code)),
+	)
+
+	stats.Record(ctx, MHTTPLatency.M(float64(latency)/float64(time.Millisecond)))
+}
+
+func RecordSyncSweep(pool string, duration time.Duration) {
+	ctx, _ := tag.New(
+		context.Background(),
+		tag.Insert(KeyPool, pool),
+	)
+
+	stats.Record(ctx, MSyncSweep.M(float64(duration)/float64(time.Millisecond)))
+}
+
+func RecordSyncQueue(pool string, queued int64) {
+	ctx, _ := tag.New(
+		context.Background(),
+		tag.Insert(KeyPool, pool),
+	)
+
+	stats.Record(ctx, MSyncQueue.M(queued))
+}
+
+func RecordSyncLag(pool string, lag time.Duration) {
+	ctx, _ := tag.New(
+		context.Background(),
+		tag.Insert(KeyPool, pool),
+	)
+
+	stats.Record(ctx, MSyncLag.M(lag.Seconds()))
+}
+
+func RecordSyncError(pool, cause string) {
+	ctx, _ := tag.New(
+		context.Background(),
+		tag.Insert(KeyPool, pool),
+		tag.Insert(KeyCause, cause),
+	)
+
+	stats.Record(ctx, MSyncError.M(1))
+}
+
+func RecordSyncTransition(pool, state string) {
+	ctx, _ := tag.New(
+		context.Background(),
+		tag.Insert(KeyPool, pool),
+		tag.Insert(KeyState, state),
+	)
+
+	stats.Record(ctx, MSyncTransition.M(1))
+}
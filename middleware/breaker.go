@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rueian/godemand/metrics"
+	"github.com/rueian/godemand/plugin"
+	"github.com/rueian/godemand/types"
+)
+
+// CircuitOpenErr wraps the plugin.AcquireLaterErr, so that clients know to retry later.
+var CircuitOpenErr = fmt.Errorf("circuit breaker is open: %w", plugin.AcquireLaterErr)
+
+type BreakerState int64
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerHalfOpen
+	BreakerOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerHalfOpen:
+		return "half-open"
+	case BreakerOpen:
+		return "open"
+	}
+	return "unknown"
+}
+
+// Breaker opens the circuit after the consecutive failures, and rejects calls with CircuitOpenErr during the cooldown.
+// After the cooldown, a single trial call is let through, which closes the circuit on success or opens it again on failure.
+// The state is recorded by metrics.RecordBreakerState with the pool name.
+type Breaker struct {
+	pool     string
+	failures int
+	cooldown time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	count    int
+	openedAt time.Time
+}
+
+func NewBreaker(pool string, failures int, cooldown time.Duration) *Breaker {
+	return &Breaker{pool: pool, failures: failures, cooldown: cooldown}
+}
+
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+func (b *Breaker) Middleware() Middleware {
+	return Intercept(func(method string, call Call) (interface{}, error) {
+		if err := b.allow(); err != nil {
+			metrics.RecordBreakerReject(b.pool)
+			return nil, fmt.Errorf("fail to %s of pool %s: %w", method, b.pool, err)
+		}
+		ret, err := call()
+		b.done(err)
+		return ret, err
+	})
+}
+
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerHalfOpen:
+		// the trial call is still in flight
+		return CircuitOpenErr
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return CircuitOpenErr
+		}
+		b.set(BreakerHalfOpen)
+	}
+	return nil
+}
+
+func (b *Breaker) done(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if errors.Is(err, ConcurrencyLimitErr) {
+		// the call is rejected by the limiter without reaching the plugin, which is neither a failure nor a success,
+		// and a rejected trial call leaves the circuit open but past its cooldown, so that the next call is the trial.
+		if b.state == BreakerHalfOpen {
+			b.set(BreakerOpen)
+		}
+		return
+	}
+	if err == nil || errors.Is(err, types.ExternalListNotSupportedErr) {
+		b.count = 0
+		b.set(BreakerClosed)
+		return
+	}
+	if b.count++; b.state == BreakerHalfOpen || b.count >= b.failures {
+		b.openedAt = time.Now()
+		b.set(BreakerOpen)
+	}
+}
+
+func (b *Breaker) set(state BreakerState) {
+	b.state = state
+	metrics.RecordBreakerState(b.pool, int64(state))
+}
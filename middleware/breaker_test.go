@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/plugin"
+	"github.com/rueian/godemand/types"
+	"github.com/rueian/godemand/types/mock"
+)
+
+var _ = Describe("Breaker", func() {
+	var ctrl *gomock.Controller
+	var controller *mock.MockController
+	var breaker *Breaker
+	var wrapped types.Controller
+	var res types.Resource
+	var fail error
+
+	BeforeEach(func() {
+		ctrl = gomock.NewController(GinkgoT())
+		controller = mock.NewMockController(ctrl)
+		breaker = NewBreaker("pool1", 2, 50*time.Millisecond)
+		wrapped = breaker.Middleware()(controller)
+		res = types.Resource{ID: "a", PoolID: "pool1"}
+		fail = errors.New("fail")
+	})
+
+	AfterEach(func() {
+		ctrl.Finish()
+	})
+
+	open := func() {
+		controller.EXPECT().SyncResource(res, nil).Return(types.Resource{}, fail).Times(2)
+		for i := 0; i < 2; i++ {
+			_, err := wrapped.SyncResource(res, nil)
+			Expect(err).To(Equal(fail))
+		}
+		Expect(breaker.State()).To(Equal(BreakerOpen))
+	}
+
+	It("open after consecutive failures and fail fast with a retryable error", func() {
+		open()
+		_, err := wrapped.SyncResource(res, nil)
+		Expect(errors.Is(err, CircuitOpenErr)).To(BeTrue())
+		Expect(errors.Is(err, plugin.AcquireLaterErr)).To(BeTrue())
+	})
+
+	It("reset the failures on success", func() {
+		gomock.InOrder(
+			controller.EXPECT().SyncResource(res, nil).Return(types.Resource{}, fail),
+			controller.EXPECT().SyncResource(res, nil).Return(res, nil),
+			controller.EXPECT().SyncResource(res, nil).Return(types.Resource{}, fail),
+		)
+		for i := 0; i < 3; i++ {
+			wrapped.SyncResource(res, nil)
+		}
+		Expect(breaker.State()).To(Equal(BreakerClosed))
+	})
+
+	It("close after a successful trial call", func() {
+		open()
+		time.Sleep(60 * time.Millisecond)
+		Expect(breaker.State()).To(Equal(BreakerHalfOpen))
+		controller.EXPECT().SyncResource(res, nil).Return(res, nil)
+		_, err := wrapped.SyncResource(res, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(breaker.State()).To(Equal(BreakerClosed))
+	})
+
+	It("open again after a failed trial call", func() {
+		open()
+		time.Sleep(60 * time.Millisecond)
+		controller.EXPECT().SyncResource(res, nil).Return(types.Resource{}, fail)
+		_, err := wrapped.SyncResource(res, nil)
+		Expect(err).To(Equal(fail))
+		_, err = wrapped.SyncResource(res, nil)
+		Expect(errors.Is(err, CircuitOpenErr)).To(BeTrue())
+	})
+
+	It("not count calls rejected by the limiter as failures", func() {
+		busy := Intercept(func(method string, call Call) (interface{}, error) {
+			return nil, ConcurrencyLimitErr
+		})
+		wrapped = Chain(controller, breaker.Middleware(), busy)
+		for i := 0; i < 3; i++ {
+			_, err := wrapped.SyncResource(res, nil)
+			Expect(errors.Is(err, ConcurrencyLimitErr)).To(BeTrue())
+		}
+		Expect(breaker.State()).To(Equal(BreakerClosed))
+	})
+
+	It("let the next call be the trial if the trial call is rejected by the limiter", func() {
+		open()
+		time.Sleep(60 * time.Millisecond)
+		busy := Intercept(func(method string, call Call) (interface{}, error) {
+			return nil, ConcurrencyLimitErr
+		})
+		_, err := Chain(controller, breaker.Middleware(), busy).SyncResource(res, nil)
+		Expect(errors.Is(err, ConcurrencyLimitErr)).To(BeTrue())
+		controller.EXPECT().SyncResource(res, nil).Return(res, nil)
+		_, err = wrapped.SyncResource(res, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(breaker.State()).To(Equal(BreakerClosed))
+	})
+
+	It("not count unsupported ListExternal as failures", func() {
+		for i := 0; i < 3; i++ {
+			_, err := wrapped.(types.ExternalLister).ListExternal(types.ResourcePool{ID: "pool1"}, nil)
+			Expect(errors.Is(err, types.ExternalListNotSupportedErr)).To(BeTrue())
+		}
+		Expect(breaker.State()).To(Equal(BreakerClosed))
+	})
+})
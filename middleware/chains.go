@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/rueian/godemand/config"
+	"github.com/rueian/godemand/types"
+)
+
+// Chains builds the middlewares of each pool by its config, and keeps them across calls,
+// so that the breaker and the limiter of a pool are shared by the api and the syncers.
+// The middlewares of a pool are rebuilt once its config is changed.
+type Chains struct {
+	mu    sync.Mutex
+	pools map[string]*chain
+}
+
+type chain struct {
+	config      config.MiddlewareConfig
+	middlewares []Middleware
+	breaker     *Breaker
+}
+
+func NewChains() *Chains {
+	return &Chains{pools: make(map[string]*chain)}
+}
+
+// Wrap decorates the controller by the middlewares of the pool. A nil Chains returns the controller as is.
+func (c *Chains) Wrap(poolID string, cfg config.MiddlewareConfig, controller types.Controller) types.Controller {
+	if c == nil {
+		return controller
+	}
+	return Chain(controller, c.get(poolID, cfg).middlewares...)
+}
+
+// Breaker returns the breaker of the pool, or nil if the pool doesn't have one.
+func (c *Chains) Breaker(poolID string) *Breaker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ch, ok := c.pools[poolID]; ok {
+		return ch.breaker
+	}
+	return nil
+}
+
+func (c *Chains) get(poolID string, cfg config.MiddlewareConfig) *chain {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ch, ok := c.pools[poolID]; ok && reflect.DeepEqual(ch.config, cfg) {
+		return ch
+	}
+	ch := build(poolID, cfg)
+	c.pools[poolID] = ch
+	return ch
+}
+
+// build orders the middlewares as retry, breaker, timeout and limiter, so that each attempt is counted by the breaker,
+// and the calls left running after timeout still hold their slots of the limiter. The breaker doesn't count the calls
+// rejected by the limiter, and the retry doesn't retry timed out calls.
+func build(poolID string, cfg config.MiddlewareConfig) *chain {
+	ch := &chain{config: cfg}
+	if cfg.Retry.Attempts > 1 {
+		ch.middlewares = append(ch.middlewares, Retry(cfg.Retry.Attempts, cfg.Retry.Interval, cfg.Retry.MaxInterval))
+	}
+	if cfg.Breaker.Failures > 0 {
+		ch.breaker = NewBreaker(poolID, cfg.Breaker.Failures, cfg.Breaker.Cooldown)
+		ch.middlewares = append(ch.middlewares, ch.breaker.Middleware())
+	}
+	if cfg.Timeout > 0 {
+		ch.middlewares = append(ch.middlewares, Timeout(cfg.Timeout))
+	}
+	if cfg.Concurrency.Limit > 0 {
+		ch.middlewares = append(ch.middlewares, Limit(cfg.Concurrency.Limit, cfg.Concurrency.Wait))
+	}
+	return ch
+}
@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rueian/godemand/plugin"
+	"github.com/rueian/godemand/types"
+)
+
+const (
+	FindResource = "FindResource"
+	SyncResource = "SyncResource"
+	ListExternal = "ListExternal"
+)
+
+var (
+	CallTimeoutErr = errors.New("controller call timeout")
+	// ConcurrencyLimitErr wraps the plugin.AcquireLaterErr, so that clients know to retry later.
+	ConcurrencyLimitErr = fmt.Errorf("too many concurrent controller calls: %w", plugin.AcquireLaterErr)
+)
+
+// Middleware decorates a controller, and the returned controller also implements types.ExternalLister,
+// which returns types.ExternalListNotSupportedErr if the decorated one doesn't,
+// and types.ContextController, which passes the context to the decorated one.
+type Middleware func(next types.Controller) types.Controller
+
+// Chain decorates the controller by the middlewares, where the first one is the outermost.
+func Chain(controller types.Controller, middlewares ...Middleware) types.Controller {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		controller = middlewares[i](controller)
+	}
+	return controller
+}
+
+// Call invokes the next controller and returns its result.
+type Call func() (interface{}, error)
+
+// Interceptor is invoked with the method name for each controller call.
+type Interceptor func(method string, call Call) (interface{}, error)
+
+// Intercept makes a Middleware from the Interceptor.
+func Intercept(interceptor Interceptor) Middleware {
+	return func(next types.Controller) types.Controller {
+		return &intercepted{next: next, interceptor: interceptor}
+	}
+}
+
+type intercepted struct {
+	next        types.Controller
+	interceptor Interceptor
+}
+
+func (c *intercepted) FindResource(pool types.ResourcePool, params map[string]interface{}) (types.Resource, error) {
+	return c.FindResourceContext(context.Background(), pool, params)
+}
+
+func (c *intercepted) SyncResource(resource types.Resource, params map[string]interface{}) (types.Resource, error) {
+	return c.SyncResourceContext(context.Background(), resource, params)
+}
+
+func (c *intercepted) FindResourceContext(ctx context.Context, pool types.ResourcePool, params map[string]interface{}) (types.Resource, error) {
+	ret, err := c.interceptor(FindResource, func() (interface{}, error) {
+		return types.FindResource(ctx, c.next, pool, params)
+	})
+	res, _ := ret.(types.Resource)
+	return res, err
+}
+
+func (c *intercepted) SyncResourceContext(ctx context.Context, resource types.Resource, params map[string]interface{}) (types.Resource, error) {
+	ret, err := c.interceptor(SyncResource, func() (interface{}, error) {
+		return types.SyncResource(ctx, c.next, resource, params)
+	})
+	res, _ := ret.(types.Resource)
+	return res, err
+}
+
+func (c *intercepted) ListExternal(pool types.ResourcePool, params map[string]interface{}) ([]types.Resource, error) {
+	ret, err := c.interceptor(ListExternal, func() (interface{}, error) {
+		lister, ok := c.next.(types.ExternalLister)
+		if !ok {
+			return nil, fmt.Errorf("fail to list external resources: %w", types.ExternalListNotSupportedErr)
+		}
+		return lister.ListExternal(pool, params)
+	})
+	res, _ := ret.([]types.Resource)
+	return res, err
+}
+
+// Timeout returns CallTimeoutErr if the call doesn't return in time. The call is left running in the background,
+// since controllers can't be cancelled.
+func Timeout(timeout time.Duration) Middleware {
+	return Intercept(func(method string, call Call) (interface{}, error) {
+		type result struct {
+			ret interface{}
+			err error
+		}
+		ch := make(chan result, 1)
+		go func() {
+			ret, err := call()
+			ch <- result{ret: ret, err: err}
+		}()
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case r := <-ch:
+			return r.ret, r.err
+		case <-timer.C:
+			return nil, fmt.Errorf("fail to %s in %s: %w", method, timeout, CallTimeoutErr)
+		}
+	})
+}
+
+// Retry retries the failed SyncResource up to the attempts with exponential backoff from the interval to the maxInterval.
+// Other methods are not retried since FindResource may create resources, and errors of open circuits are returned at once.
+// Timed out calls are not retried either, since they are still running in the background.
+func Retry(attempts int, interval, maxInterval time.Duration) Middleware {
+	return Intercept(func(method string, call Call) (ret interface{}, err error) {
+		if method != SyncResource {
+			return call()
+		}
+		backoff := interval
+		for i := 0; i < attempts; i++ {
+			if i > 0 {
+				time.Sleep(backoff)
+				if backoff *= 2; maxInterval > 0 && backoff > maxInterval {
+					backoff = maxInterval
+				}
+			}
+			if ret, err = call(); err == nil || errors.Is(err, CircuitOpenErr) || errors.Is(err, CallTimeoutErr) {
+				return ret, err
+			}
+		}
+		return ret, err
+	})
+}
+
+// Limit allows at most limit concurrent calls, and a call waits for a slot up to the wait before failing with ConcurrencyLimitErr.
+func Limit(limit int, wait time.Duration) Middleware {
+	slots := make(chan struct{}, limit)
+	return Intercept(func(method string, call Call) (interface{}, error) {
+		select {
+		case slots <- struct{}{}:
+		default:
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case slots <- struct{}{}:
+			case <-timer.C:
+				return nil, fmt.Errorf("fail to %s with %d calls in flight: %w", method, limit, ConcurrencyLimitErr)
+			}
+		}
+		defer func() { <-slots }()
+		return call()
+	})
+}
@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/config"
+	"github.com/rueian/godemand/plugin"
+	"github.com/rueian/godemand/types"
+	"github.com/rueian/godemand/types/mock"
+)
+
+var _ = Describe("Middleware", func() {
+	var ctrl *gomock.Controller
+	var controller *mock.MockController
+	var pool types.ResourcePool
+	var res types.Resource
+	var fail error
+
+	BeforeEach(func() {
+		ctrl = gomock.NewController(GinkgoT())
+		controller = mock.NewMockController(ctrl)
+		pool = types.ResourcePool{ID: "pool1"}
+		res = types.Resource{ID: "a", PoolID: "pool1"}
+		fail = errors.New("fail")
+	})
+
+	AfterEach(func() {
+		ctrl.Finish()
+	})
+
+	Describe("Chain", func() {
+		It("apply the first middleware as the outermost", func() {
+			var order []string
+			trace := func(name string) Middleware {
+				return Intercept(func(method string, call Call) (interface{}, error) {
+					order = append(order, name+" "+method)
+					return call()
+				})
+			}
+			controller.EXPECT().FindResource(pool, nil).Return(res, nil)
+			ret, err := Chain(controller, trace("a"), trace("b")).FindResource(pool, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ret).To(Equal(res))
+			Expect(order).To(Equal([]string{"a FindResource", "b FindResource"}))
+		})
+
+		It("return ExternalListNotSupportedErr if the controller isn't a lister", func() {
+			_, err := Chain(controller, Timeout(time.Second)).(types.ExternalLister).ListExternal(pool, nil)
+			Expect(errors.Is(err, types.ExternalListNotSupportedErr)).To(BeTrue())
+		})
+	})
+
+	Describe("Timeout", func() {
+		It("return the result in time", func() {
+			controller.EXPECT().SyncResource(res, nil).Return(res, nil)
+			ret, err := Timeout(time.Second)(controller).SyncResource(res, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ret).To(Equal(res))
+		})
+
+		It("return CallTimeoutErr for slow calls", func() {
+			controller.EXPECT().SyncResource(res, nil).DoAndReturn(func(types.Resource, map[string]interface{}) (types.Resource, error) {
+				time.Sleep(200 * time.Millisecond)
+				return res, nil
+			})
+			_, err := Timeout(50*time.Millisecond)(controller).SyncResource(res, nil)
+			Expect(errors.Is(err, CallTimeoutErr)).To(BeTrue())
+			time.Sleep(200 * time.Millisecond)
+		})
+	})
+
+	Describe("Retry", func() {
+		It("retry SyncResource with backoff", func() {
+			gomock.InOrder(
+				controller.EXPECT().SyncResource(res, nil).Return(types.Resource{}, fail).Times(2),
+				controller.EXPECT().SyncResource(res, nil).Return(res, nil),
+			)
+			start := time.Now()
+			ret, err := Retry(3, 20*time.Millisecond, 0)(controller).SyncResource(res, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ret).To(Equal(res))
+			Expect(time.Since(start)).To(BeNumerically(">=", 60*time.Millisecond))
+		})
+
+		It("return the last error after all attempts", func() {
+			controller.EXPECT().SyncResource(res, nil).Return(types.Resource{}, fail).Times(3)
+			_, err := Retry(3, time.Millisecond, time.Millisecond)(controller).SyncResource(res, nil)
+			Expect(err).To(Equal(fail))
+		})
+
+		It("not retry FindResource", func() {
+			controller.EXPECT().FindResource(pool, nil).Return(types.Resource{}, fail)
+			_, err := Retry(3, time.Millisecond, 0)(controller).FindResource(pool, nil)
+			Expect(err).To(Equal(fail))
+		})
+
+		It("not retry open circuit", func() {
+			open := Intercept(func(method string, call Call) (interface{}, error) {
+				return nil, CircuitOpenErr
+			})
+			_, err := Chain(controller, Retry(3, time.Millisecond, 0), open).SyncResource(res, nil)
+			Expect(errors.Is(err, CircuitOpenErr)).To(BeTrue())
+		})
+
+		It("not retry timed out calls", func() {
+			controller.EXPECT().SyncResource(res, nil).DoAndReturn(func(types.Resource, map[string]interface{}) (types.Resource, error) {
+				time.Sleep(50 * time.Millisecond)
+				return res, nil
+			})
+			_, err := Chain(controller, Retry(3, time.Millisecond, 0), Timeout(10*time.Millisecond)).SyncResource(res, nil)
+			Expect(errors.Is(err, CallTimeoutErr)).To(BeTrue())
+			time.Sleep(50 * time.Millisecond)
+		})
+	})
+
+	Describe("Limit", func() {
+		It("reject calls over the limit after waiting", func() {
+			entered, release := make(chan struct{}), make(chan struct{})
+			controller.EXPECT().SyncResource(res, nil).DoAndReturn(func(types.Resource, map[string]interface{}) (types.Resource, error) {
+				close(entered)
+				<-release
+				return res, nil
+			})
+			limited := Limit(1, 50*time.Millisecond)(controller)
+
+			done := make(chan error)
+			go func() {
+				_, err := limited.SyncResource(res, nil)
+				done <- err
+			}()
+			<-entered
+
+			start := time.Now()
+			_, err := limited.SyncResource(res, nil)
+			Expect(errors.Is(err, ConcurrencyLimitErr)).To(BeTrue())
+			Expect(errors.Is(err, plugin.AcquireLaterErr)).To(BeTrue())
+			Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+
+			close(release)
+			Expect(<-done).NotTo(HaveOccurred())
+			controller.EXPECT().SyncResource(res, nil).Return(res, nil)
+			_, err = limited.SyncResource(res, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Describe("Chains", func() {
+		var chains *Chains
+		var cfg config.MiddlewareConfig
+
+		BeforeEach(func() {
+			chains = NewChains()
+			cfg = config.MiddlewareConfig{Breaker: config.BreakerConfig{Failures: 1, Cooldown: time.Minute}}
+		})
+
+		It("keep the breaker of the pool across calls", func() {
+			controller.EXPECT().SyncResource(res, nil).Return(types.Resource{}, fail)
+			_, err := chains.Wrap("pool1", cfg, controller).SyncResource(res, nil)
+			Expect(err).To(Equal(fail))
+			_, err = chains.Wrap("pool1", cfg, controller).SyncResource(res, nil)
+			Expect(errors.Is(err, CircuitOpenErr)).To(BeTrue())
+			Expect(chains.Breaker("pool1").State()).To(Equal(BreakerOpen))
+			Expect(chains.Breaker("pool2")).To(BeNil())
+		})
+
+		It("rebuild the middlewares once the config is changed", func() {
+			controller.EXPECT().SyncResource(res, nil).Return(types.Resource{}, fail)
+			chains.Wrap("pool1", cfg, controller).SyncResource(res, nil)
+
+			cfg.Breaker.Failures = 2
+			controller.EXPECT().SyncResource(res, nil).Return(res, nil)
+			_, err := chains.Wrap("pool1", cfg, controller).SyncResource(res, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("return the controller as is if nil", func() {
+			var chains *Chains
+			Expect(chains.Wrap("pool1", cfg, controller)).To(BeIdenticalTo(controller))
+		})
+	})
+})
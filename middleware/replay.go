@@ -0,0 +1,257 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/rueian/godemand/plugin"
+	"github.com/rueian/godemand/types"
+)
+
+var ReplayMismatchErr = errors.New("no recorded call matches")
+
+// Recording is a controller call and its response, where the Pool or the Resource is set by the Method.
+type Recording struct {
+	Method   string
+	Pool     *types.ResourcePool `json:",omitempty"`
+	Resource *types.Resource     `json:",omitempty"`
+	Params   map[string]interface{}
+	Result   types.Resource
+	External []types.Resource `json:",omitempty"`
+	Error    string           `json:",omitempty"`
+	// ErrorKind names the known sentinel wrapped by the Error, so that it is still matched by errors.Is when replayed.
+	ErrorKind string `json:",omitempty"`
+}
+
+// errorKinds are the sentinels restored by the Replayer, where the more specific ones go first.
+var errorKinds = []struct {
+	kind string
+	err  error
+}{
+	{kind: "circuit_open", err: CircuitOpenErr},
+	{kind: "concurrency_limit", err: ConcurrencyLimitErr},
+	{kind: "call_timeout", err: CallTimeoutErr},
+	{kind: "external_list_not_supported", err: types.ExternalListNotSupportedErr},
+	{kind: "acquire_later", err: plugin.AcquireLaterErr},
+}
+
+func errorKind(err error) string {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.kind
+		}
+	}
+	return ""
+}
+
+// replayedError is a recorded error, which keeps the recorded message and wraps the sentinel of its kind.
+type replayedError struct {
+	msg  string
+	kind error
+}
+
+func (e *replayedError) Error() string {
+	return e.msg
+}
+
+func (e *replayedError) Unwrap() error {
+	return e.kind
+}
+
+func replayedErr(rec Recording) error {
+	for _, k := range errorKinds {
+		if k.kind == rec.ErrorKind {
+			return &replayedError{msg: rec.Error, kind: k.err}
+		}
+	}
+	return errors.New(rec.Error)
+}
+
+// Recorder writes each controller call and its response to the file as a line of json.
+type Recorder struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+func (r *Recorder) Middleware() Middleware {
+	return func(next types.Controller) types.Controller {
+		return &recorded{next: next, recorder: r}
+	}
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+func (r *Recorder) write(rec Recording, err error) {
+	if err != nil {
+		rec.Error = err.Error()
+		rec.ErrorKind = errorKind(err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enc.Encode(rec)
+}
+
+type recorded struct {
+	next     types.Controller
+	recorder *Recorder
+}
+
+func (c *recorded) FindResource(pool types.ResourcePool, params map[string]interface{}) (types.Resource, error) {
+	return c.FindResourceContext(context.Background(), pool, params)
+}
+
+func (c *recorded) SyncResource(resource types.Resource, params map[string]interface{}) (types.Resource, error) {
+	return c.SyncResourceContext(context.Background(), resource, params)
+}
+
+func (c *recorded) FindResourceContext(ctx context.Context, pool types.ResourcePool, params map[string]interface{}) (types.Resource, error) {
+	res, err := types.FindResource(ctx, c.next, pool, params)
+	c.recorder.write(Recording{Method: FindResource, Pool: &pool, Params: params, Result: res}, err)
+	return res, err
+}
+
+func (c *recorded) SyncResourceContext(ctx context.Context, resource types.Resource, params map[string]interface{}) (types.Resource, error) {
+	res, err := types.SyncResource(ctx, c.next, resource, params)
+	c.recorder.write(Recording{Method: SyncResource, Resource: &resource, Params: params, Result: res}, err)
+	return res, err
+}
+
+func (c *recorded) ListExternal(pool types.ResourcePool, params map[string]interface{}) ([]types.Resource, error) {
+	var res []types.Resource
+	lister, ok := c.next.(types.ExternalLister)
+	err := fmt.Errorf("fail to list external resources: %w", types.ExternalListNotSupportedErr)
+	if ok {
+		res, err = lister.ListExternal(pool, params)
+	}
+	c.recorder.write(Recording{Method: ListExternal, Pool: &pool, Params: params, External: res}, err)
+	return res, err
+}
+
+// Matcher decides if the call matches the recording.
+type Matcher func(recording, call Recording) bool
+
+// MatchIDs matches the method, the id of the pool or the resource, and the params,
+// which ignores the other fields changing between runs, such as timestamps.
+func MatchIDs(recording, call Recording) bool {
+	if recording.Method != call.Method || !equalJSON(recording.Params, call.Params) {
+		return false
+	}
+	if call.Pool != nil {
+		return recording.Pool != nil && recording.Pool.ID == call.Pool.ID
+	}
+	return recording.Resource != nil && call.Resource != nil && recording.Resource.ID == call.Resource.ID && recording.Resource.PoolID == call.Resource.PoolID
+}
+
+// MatchExact matches all the args of the call.
+func MatchExact(recording, call Recording) bool {
+	return recording.Method == call.Method && equalJSON(recording.Pool, call.Pool) &&
+		equalJSON(recording.Resource, call.Resource) && equalJSON(recording.Params, call.Params)
+}
+
+func equalJSON(a, b interface{}) bool {
+	ja, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	jb, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(ja) == string(jb)
+}
+
+// Replayer implements types.Controller by the recordings written by the Recorder.
+// Each call consumes the first unused recording matched by the Match, and fails with ReplayMismatchErr if there is none.
+type Replayer struct {
+	Match Matcher
+
+	mu         sync.Mutex
+	recordings []Recording
+	used       []bool
+}
+
+func NewReplayer(path string) (*Replayer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := &Replayer{Match: MatchIDs}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		var rec Recording
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("fail to decode recording %d of %s: %w", len(r.recordings)+1, path, err)
+		}
+		r.recordings = append(r.recordings, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	r.used = make([]bool, len(r.recordings))
+	return r, nil
+}
+
+func (r *Replayer) FindResource(pool types.ResourcePool, params map[string]interface{}) (types.Resource, error) {
+	rec, err := r.replay(Recording{Method: FindResource, Pool: &pool, Params: params})
+	return rec.Result, err
+}
+
+func (r *Replayer) SyncResource(resource types.Resource, params map[string]interface{}) (types.Resource, error) {
+	rec, err := r.replay(Recording{Method: SyncResource, Resource: &resource, Params: params})
+	return rec.Result, err
+}
+
+func (r *Replayer) ListExternal(pool types.ResourcePool, params map[string]interface{}) ([]types.Resource, error) {
+	rec, err := r.replay(Recording{Method: ListExternal, Pool: &pool, Params: params})
+	return rec.External, err
+}
+
+// Remaining returns the recordings not replayed yet.
+func (r *Replayer) Remaining() (remaining []Recording) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, rec := range r.recordings {
+		if !r.used[i] {
+			remaining = append(remaining, rec)
+		}
+	}
+	return
+}
+
+func (r *Replayer) replay(call Recording) (Recording, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, rec := range r.recordings {
+		if r.used[i] || !r.Match(rec, call) {
+			continue
+		}
+		r.used[i] = true
+		if rec.Error != "" {
+			return rec, replayedErr(rec)
+		}
+		return rec, nil
+	}
+	args, _ := json.Marshal(call)
+	return Recording{}, fmt.Errorf("fail to replay %s: %w: %s", call.Method, ReplayMismatchErr, args)
+}
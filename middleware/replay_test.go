@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/plugin"
+	"github.com/rueian/godemand/types"
+	"github.com/rueian/godemand/types/mock"
+)
+
+var _ = Describe("Record and replay", func() {
+	var ctrl *gomock.Controller
+	var controller *mock.MockController
+	var dir, path string
+	var pool types.ResourcePool
+	var res types.Resource
+	var params map[string]interface{}
+
+	BeforeEach(func() {
+		ctrl = gomock.NewController(GinkgoT())
+		controller = mock.NewMockController(ctrl)
+		dir, _ = ioutil.TempDir("", "replay")
+		path = filepath.Join(dir, "calls.json")
+		res = types.Resource{ID: "a", PoolID: "pool1", CreatedAt: time.Now()}
+		pool = types.ResourcePool{ID: "pool1", Resources: map[string]types.Resource{"a": res}}
+		params = map[string]interface{}{"size": "small"}
+
+		recorder, err := NewRecorder(path)
+		Expect(err).NotTo(HaveOccurred())
+		recorded := recorder.Middleware()(controller)
+
+		controller.EXPECT().FindResource(pool, params).Return(res, nil)
+		controller.EXPECT().SyncResource(res, params).Return(types.Resource{}, errors.New("quota exceeded"))
+		recorded.FindResource(pool, params)
+		recorded.SyncResource(res, params)
+		recorded.(types.ExternalLister).ListExternal(pool, params)
+		Expect(recorder.Close()).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		ctrl.Finish()
+		os.RemoveAll(dir)
+	})
+
+	It("replay the recorded responses", func() {
+		replayer, err := NewReplayer(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(replayer.Remaining()).To(HaveLen(3))
+
+		// timestamps of the pool are changed between runs
+		res.CreatedAt = time.Now()
+		pool.Resources["a"] = res
+
+		_, err = replayer.SyncResource(res, params)
+		Expect(err).To(MatchError("quota exceeded"))
+		found, err := replayer.FindResource(pool, params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found.ID).To(Equal("a"))
+		_, err = replayer.ListExternal(pool, params)
+		Expect(errors.Is(err, types.ExternalListNotSupportedErr)).To(BeTrue())
+		Expect(replayer.Remaining()).To(BeEmpty())
+	})
+
+	It("restore the known sentinels of recorded errors", func() {
+		path = filepath.Join(dir, "errors.json")
+		recorder, err := NewRecorder(path)
+		Expect(err).NotTo(HaveOccurred())
+		open := Intercept(func(method string, call Call) (interface{}, error) {
+			return nil, CircuitOpenErr
+		})
+		controller.EXPECT().SyncResource(res, params).Return(types.Resource{}, fmt.Errorf("locked: %w", plugin.AcquireLaterErr))
+		Chain(controller, recorder.Middleware(), open).FindResource(pool, params)
+		Chain(controller, recorder.Middleware()).SyncResource(res, params)
+		Expect(recorder.Close()).NotTo(HaveOccurred())
+
+		replayer, err := NewReplayer(path)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = replayer.FindResource(pool, params)
+		Expect(err).To(MatchError(CircuitOpenErr.Error()))
+		Expect(errors.Is(err, CircuitOpenErr)).To(BeTrue())
+		Expect(errors.Is(err, plugin.AcquireLaterErr)).To(BeTrue())
+		_, err = replayer.SyncResource(res, params)
+		Expect(err).To(MatchError("locked: please acquire later"))
+		Expect(errors.Is(err, plugin.AcquireLaterErr)).To(BeTrue())
+		Expect(errors.Is(err, CircuitOpenErr)).To(BeFalse())
+	})
+
+	It("fail on calls not matched", func() {
+		replayer, err := NewReplayer(path)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = replayer.FindResource(pool, map[string]interface{}{"size": "large"})
+		Expect(errors.Is(err, ReplayMismatchErr)).To(BeTrue())
+
+		replayer.FindResource(pool, params)
+		_, err = replayer.FindResource(pool, params)
+		Expect(errors.Is(err, ReplayMismatchErr)).To(BeTrue())
+	})
+
+	It("match all the args by MatchExact", func() {
+		replayer, err := NewReplayer(path)
+		Expect(err).NotTo(HaveOccurred())
+		replayer.Match = MatchExact
+
+		res.CreatedAt = time.Now().Add(time.Hour)
+		_, err = replayer.SyncResource(res, params)
+		Expect(errors.Is(err, ReplayMismatchErr)).To(BeTrue())
+	})
+})
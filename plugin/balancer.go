@@ -0,0 +1,93 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+
+	"github.com/rueian/godemand/types"
+)
+
+var UnknownBalanceErr = errors.New("unknown plugin balance")
+
+// balancer spreads calls of a plugin across its instances, either to the least loaded one,
+// or to the one picked by the pool id so that calls of a pool are served by the same process.
+// It falls back to the least loaded one if the picked instance is not running.
+type balancer struct {
+	instances []*Launcher // nil for instances not running
+	balance   string
+}
+
+func (b *balancer) FindResource(pool types.ResourcePool, params map[string]interface{}) (types.Resource, error) {
+	return b.pick(pool.ID).FindResource(pool, params)
+}
+
+func (b *balancer) SyncResource(resource types.Resource, params map[string]interface{}) (types.Resource, error) {
+	return b.pick(resource.PoolID).SyncResource(resource, params)
+}
+
+func (b *balancer) FindResourceContext(ctx context.Context, pool types.ResourcePool, params map[string]interface{}) (types.Resource, error) {
+	return types.FindResource(ctx, b.pick(pool.ID), pool, params)
+}
+
+func (b *balancer) SyncResourceContext(ctx context.Context, resource types.Resource, params map[string]interface{}) (types.Resource, error) {
+	return types.SyncResource(ctx, b.pick(resource.PoolID), resource, params)
+}
+
+func (b *balancer) ListExternal(pool types.ResourcePool, params map[string]interface{}) ([]types.Resource, error) {
+	lister, ok := b.pick(pool.ID).(types.ExternalLister)
+	if !ok {
+		return nil, fmt.Errorf("fail to list external resources: %w", types.ExternalListNotSupportedErr)
+	}
+	return lister.ListExternal(pool, params)
+}
+
+func (b *balancer) pick(poolID string) types.Controller {
+	if b.balance == types.BalancePool {
+		h := fnv.New32a()
+		h.Write([]byte(poolID))
+		if l := b.instances[h.Sum32()%uint32(len(b.instances))]; l != nil {
+			return l.Controller
+		}
+	}
+
+	var picked types.Controller
+	min := -1
+	offset := rand.Intn(len(b.instances))
+	for i := range b.instances {
+		l := b.instances[(offset+i)%len(b.instances)]
+		if l == nil {
+			continue
+		}
+		if load := l.calls.load(); min < 0 || load < min {
+			picked, min = l.Controller, load
+		}
+	}
+	return picked
+}
+
+func instances(param types.CmdParam) int {
+	if param.Instances < 1 {
+		return 1
+	}
+	return param.Instances
+}
+
+func running(slots []*Launcher) (n int) {
+	for _, l := range slots {
+		if l != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func validBalance(param types.CmdParam) error {
+	switch param.Balance {
+	case "", types.BalanceLoad, types.BalancePool:
+		return nil
+	}
+	return fmt.Errorf("fail to launch plugin %s with balance %q: %w", param.Name, param.Balance, UnknownBalanceErr)
+}
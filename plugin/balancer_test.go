@@ -0,0 +1,47 @@
+package plugin
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/types"
+)
+
+var _ = Describe("balancer", func() {
+	var b *balancer
+
+	instance := func(inflight int) *Launcher {
+		client := &rpcClient{tracker: &tracker{inflight: inflight}}
+		return &Launcher{Controller: client, calls: client.tracker}
+	}
+
+	BeforeEach(func() {
+		b = &balancer{instances: []*Launcher{instance(2), nil, instance(1), instance(3)}}
+	})
+
+	It("pick the least loaded instance", func() {
+		for i := 0; i < 10; i++ {
+			Expect(b.pick("pool1")).To(BeIdenticalTo(b.instances[2].Controller))
+		}
+	})
+
+	Context("by pool", func() {
+		BeforeEach(func() {
+			b.balance = types.BalancePool
+		})
+
+		It("pick the same instance for the same pool", func() {
+			picked := b.pick("pool2")
+			for i := 0; i < 10; i++ {
+				Expect(b.pick("pool2")).To(BeIdenticalTo(picked))
+			}
+		})
+
+		It("fall back to the least loaded one if the picked is not running", func() {
+			for i := 0; i < len(b.instances); i++ {
+				b.instances[i] = nil
+			}
+			b.instances[3] = instance(0)
+			Expect(b.pick("pool2")).To(BeIdenticalTo(b.instances[3].Controller))
+		})
+	})
+})
@@ -0,0 +1,172 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rueian/godemand/types"
+)
+
+var (
+	ChecksumMismatchErr = errors.New("plugin binary checksum mismatch")
+	OwnerNotAllowedErr  = errors.New("plugin binary owner not allowed")
+)
+
+// VerificationError is returned by Launcher.Launch when the plugin binary doesn't match the pinned checksum or owners.
+// It wraps either ChecksumMismatchErr or OwnerNotAllowedErr.
+type VerificationError struct {
+	Path     string
+	Expected string
+	Actual   string
+	Err      error
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("fail to verify plugin binary %s, expected %s but got %s: %s", e.Path, e.Expected, e.Actual, e.Err)
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+// binary is a snapshot of a plugin executable on disk.
+type binary struct {
+	Path     string
+	ModTime  time.Time
+	Size     int64
+	Checksum string
+	Owner    string
+}
+
+// openBinary opens the executable found by the path, see openFile.
+func openBinary(path string) (binary, *os.File, error) {
+	path, err := exec.LookPath(path)
+	if err != nil {
+		return binary{}, nil, err
+	}
+	return openFile(path)
+}
+
+// openFile opens the file and takes its snapshot from the opened file instead of the path,
+// so that the verified snapshot is what will be executed even if the path is replaced afterwards.
+func openFile(path string) (binary, *os.File, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return binary{}, nil, err
+	}
+	f, err := os.Open(abs)
+	if err != nil {
+		return binary{}, nil, err
+	}
+	b, err := snapshot(f, binary{Path: abs})
+	if err != nil {
+		f.Close()
+		return binary{}, nil, err
+	}
+	return b, f, nil
+}
+
+// readFile reads the file once, and takes its snapshot from the read content which is then loaded by the controller,
+// such as a wasm module or a starlark script.
+func readFile(path string) (b binary, content []byte, err error) {
+	if b.Path, err = filepath.Abs(path); err != nil {
+		return binary{}, nil, err
+	}
+	f, err := os.Open(b.Path)
+	if err != nil {
+		return binary{}, nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return binary{}, nil, err
+	}
+	if content, err = ioutil.ReadAll(f); err != nil {
+		return binary{}, nil, err
+	}
+	sum := sha256.Sum256(content)
+	b.ModTime, b.Size, b.Owner, b.Checksum = info.ModTime(), info.Size(), owner(info), hex.EncodeToString(sum[:])
+	return b, content, nil
+}
+
+// snapshot takes the snapshot of the opened file, where the content hash is reused from the prev if its mtime and size are unchanged.
+// The file is rewound after hashed.
+func snapshot(f *os.File, prev binary) (binary, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return prev, err
+	}
+	b := binary{Path: prev.Path, ModTime: info.ModTime(), Size: info.Size(), Owner: owner(info)}
+	if prev.Checksum != "" && b.ModTime.Equal(prev.ModTime) && b.Size == prev.Size {
+		b.Checksum = prev.Checksum
+		return b, nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return prev, err
+	}
+	b.Checksum = hex.EncodeToString(h.Sum(nil))
+	_, err = f.Seek(0, io.SeekStart)
+	return b, err
+}
+
+// modified returns the current snapshot of the binary, and the content hash is only recomputed when its mtime or size changed.
+func (b binary) modified() (binary, bool, error) {
+	info, err := os.Stat(b.Path)
+	if err != nil {
+		return b, false, err
+	}
+	if info.ModTime().Equal(b.ModTime) && info.Size() == b.Size {
+		return b, false, nil
+	}
+	current := binary{Path: b.Path, ModTime: info.ModTime(), Size: info.Size(), Owner: owner(info)}
+	if current.Checksum, err = checksum(b.Path); err != nil {
+		return b, false, err
+	}
+	return current, current.Checksum != b.Checksum, nil
+}
+
+// verify checks the binary against the optional sha256 and owners pinned in the param.
+// Owners can be either user names or uids.
+func (b binary) verify(param types.CmdParam) error {
+	if param.SHA256 != "" && !strings.EqualFold(param.SHA256, b.Checksum) {
+		return &VerificationError{Path: b.Path, Expected: param.SHA256, Actual: b.Checksum, Err: ChecksumMismatchErr}
+	}
+	if len(param.Owners) == 0 {
+		return nil
+	}
+	name := b.Owner
+	if u, err := user.LookupId(b.Owner); err == nil {
+		name = u.Username
+	}
+	for _, o := range param.Owners {
+		if b.Owner != "" && (o == b.Owner || o == name) {
+			return nil
+		}
+	}
+	return &VerificationError{Path: b.Path, Expected: strings.Join(param.Owners, ","), Actual: name, Err: OwnerNotAllowedErr}
+}
+
+func checksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
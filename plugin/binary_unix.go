@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package plugin
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+func owner(info os.FileInfo) string {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return strconv.FormatUint(uint64(stat.Uid), 10)
+	}
+	return ""
+}
@@ -0,0 +1,8 @@
+package plugin
+
+import "os"
+
+// owner is not supported on windows, therefore a plugin with pinned owners will never pass the verification.
+func owner(info os.FileInfo) string {
+	return ""
+}
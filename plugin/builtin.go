@@ -0,0 +1,51 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/rueian/godemand/types"
+)
+
+// BuiltinPrefix is the prefix of a plugin path referring to a controller registered by RegisterBuiltin.
+const BuiltinPrefix = "builtin:"
+
+var BuiltinNotFoundErr = errors.New("builtin controller not registered")
+
+var builtins = struct {
+	sync.RWMutex
+	controllers map[string]types.Controller
+}{controllers: make(map[string]types.Controller)}
+
+// RegisterBuiltin registers a controller served in process, which can be used by a plugin with the path "builtin:<name>".
+// It is launched, restarted and stopped by the Launchpad like other plugins, but without a subprocess.
+func RegisterBuiltin(name string, controller types.Controller) {
+	builtins.Lock()
+	defer builtins.Unlock()
+	builtins.controllers[name] = controller
+}
+
+func UnregisterBuiltin(name string) {
+	builtins.Lock()
+	defer builtins.Unlock()
+	delete(builtins.controllers, name)
+}
+
+func builtinName(path string) (string, bool) {
+	if strings.HasPrefix(path, BuiltinPrefix) {
+		return strings.TrimPrefix(path, BuiltinPrefix), true
+	}
+	return "", false
+}
+
+func lookupBuiltin(param types.CmdParam, name string) (types.Controller, error) {
+	builtins.RLock()
+	defer builtins.RUnlock()
+	controller, ok := builtins.controllers[name]
+	if !ok {
+		return nil, fmt.Errorf("fail to launch plugin %s by %q: %w", param.Name, name, BuiltinNotFoundErr)
+	}
+	return controller, nil
+}
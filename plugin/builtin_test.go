@@ -0,0 +1,138 @@
+package plugin
+
+import (
+	"errors"
+	"net/rpc"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/types"
+	"github.com/rueian/godemand/types/mock"
+)
+
+var _ = Describe("Builtin", func() {
+	var ctrl *gomock.Controller
+	var controller *mock.MockController
+
+	BeforeEach(func() {
+		ctrl = gomock.NewController(GinkgoT())
+		controller = mock.NewMockController(ctrl)
+		RegisterBuiltin("mock", controller)
+	})
+
+	AfterEach(func() {
+		UnregisterBuiltin("mock")
+		ctrl.Finish()
+	})
+
+	Describe("Launcher", func() {
+		var launcher *Launcher
+		var launched types.Controller
+		var err error
+
+		JustBeforeEach(func() {
+			launcher = NewLauncher(types.CmdParam{Name: "builtin", Path: "builtin:mock"}, nil)
+			launched, err = launcher.Launch()
+		})
+
+		AfterEach(func() {
+			launcher.Close()
+		})
+
+		It("call the registered controller directly", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(launcher.Ping()).NotTo(HaveOccurred())
+			Expect(launcher.pid()).To(BeZero())
+
+			pool := types.ResourcePool{ID: "pool1"}
+			controller.EXPECT().FindResource(pool, map[string]interface{}{"a": 1}).Return(types.Resource{ID: "a"}, nil)
+			res, err := launched.FindResource(pool, map[string]interface{}{"a": 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.ID).To(Equal("a"))
+
+			_, err = launched.(types.ExternalLister).ListExternal(pool, nil)
+			Expect(errors.Is(err, types.ExternalListNotSupportedErr)).To(BeTrue())
+		})
+
+		It("refuse calls once shutdown", func() {
+			launcher.Shutdown(time.Second)
+			Eventually(launcher.exited).Should(BeClosed())
+			Expect(launcher.Err()).NotTo(HaveOccurred())
+			Expect(errors.Is(launcher.Ping(), rpc.ErrShutdown)).To(BeTrue())
+			_, err = launched.SyncResource(types.Resource{}, nil)
+			Expect(errors.Is(err, rpc.ErrShutdown)).To(BeTrue())
+		})
+
+		Context("closable", func() {
+			var closable *closableController
+
+			BeforeEach(func() {
+				closable = &closableController{Controller: controller}
+				RegisterBuiltin("mock", closable)
+			})
+
+			It("not close the shared controller", func() {
+				launcher.Shutdown(time.Second)
+				Eventually(launcher.exited).Should(BeClosed())
+				Expect(closable.closed).To(BeFalse())
+			})
+		})
+
+		Context("not registered", func() {
+			BeforeEach(func() {
+				UnregisterBuiltin("mock")
+			})
+			It("get BuiltinNotFoundErr", func() {
+				Expect(errors.Is(err, BuiltinNotFoundErr)).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("Launchpad", func() {
+		var launchpad *Launchpad
+
+		BeforeEach(func() {
+			launchpad = NewLaunchpad(WithDrainTimeout(time.Second))
+			Expect(launchpad.SetLaunchers(map[string]types.CmdParam{
+				"builtin": {Name: "builtin", Path: "builtin:mock", Instances: 2},
+			})).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			launchpad.Close()
+		})
+
+		It("serve, restart and stop like other plugins", func() {
+			c, err := launchpad.GetController("builtin")
+			Expect(err).NotTo(HaveOccurred())
+			controller.EXPECT().SyncResource(types.Resource{PoolID: "pool1"}, nil).Return(types.Resource{ID: "b"}, nil)
+			res, err := c.SyncResource(types.Resource{PoolID: "pool1"}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.ID).To(Equal("b"))
+
+			status := launchpad.Status()[0]
+			Expect(status.Running).To(BeTrue())
+			Expect(status.Healthy).To(BeTrue())
+			Expect(status.Instances).To(HaveLen(2))
+
+			Expect(launchpad.Restart("builtin")).NotTo(HaveOccurred())
+			Expect(launchpad.Status()[0].Restarts).To(Equal(2))
+
+			Expect(launchpad.Stop("builtin")).NotTo(HaveOccurred())
+			_, err = launchpad.GetController("builtin")
+			Expect(errors.Is(err, ControllerNotFoundErr)).To(BeTrue())
+		})
+	})
+})
+
+type closableController struct {
+	types.Controller
+	closed bool
+}
+
+func (c *closableController) Close() error {
+	c.closed = true
+	return nil
+}
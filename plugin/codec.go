@@ -0,0 +1,219 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/rpc"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rueian/godemand/types"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+var (
+	UnknownEncodingErr = errors.New("unknown plugin encoding")
+	UnknownPoolViewErr = errors.New("unknown plugin pool view")
+	PoolDeltaBaseErr   = errors.New("pool delta doesn't match the cached pool")
+)
+
+// Request is the args of the Call rpc since protocol version 2, whose Args are encoded by the Encoding.
+type Request struct {
+	Method   string
+	Encoding string
+	Args     []byte
+}
+
+// PoolDelta is sent along with the pool by PoolViewDelta. If the Base is zero, the pool is sent in full,
+// otherwise the pool only contains the resources changed since the Base revision, and the Removed ones should be dropped.
+type PoolDelta struct {
+	Base     uint64
+	Revision uint64
+	Removed  []string `json:",omitempty"`
+}
+
+type codec struct {
+	marshal   func(v interface{}) ([]byte, error)
+	unmarshal func(data []byte, v interface{}) error
+}
+
+// codecs are the encodings supported by the Server. Numbers in params and meta are decoded as float64 by json,
+// but as int64, uint64 or float64 by msgpack.
+var codecs = map[string]codec{
+	types.EncodingJSON: {marshal: json.Marshal, unmarshal: json.Unmarshal},
+	types.EncodingMsgpack: {
+		marshal: func(v interface{}) ([]byte, error) {
+			var buf bytes.Buffer
+			enc := msgpack.NewEncoder(&buf)
+			enc.SetCustomStructTag("json")
+			enc.UseCompactInts(true)
+			err := enc.Encode(v)
+			return buf.Bytes(), err
+		},
+		unmarshal: func(data []byte, v interface{}) error {
+			dec := msgpack.NewDecoder(bytes.NewReader(data))
+			dec.SetCustomStructTag("json")
+			dec.UseLooseInterfaceDecoding(true)
+			return dec.Decode(v)
+		},
+	},
+}
+
+func encodings() []string {
+	return []string{types.EncodingJSON, types.EncodingMsgpack}
+}
+
+func validWire(param types.CmdParam) error {
+	if _, ok := codecs[param.Encoding]; param.Encoding != "" && !ok {
+		return fmt.Errorf("fail to launch plugin %s with encoding %q: %w", param.Name, param.Encoding, UnknownEncodingErr)
+	}
+	switch param.PoolView {
+	case "", types.PoolViewFull, types.PoolViewSummary, types.PoolViewDelta:
+		return nil
+	}
+	return fmt.Errorf("fail to launch plugin %s with pool view %q: %w", param.Name, param.PoolView, UnknownPoolViewErr)
+}
+
+// negotiate picks the encoding wanted by the param if the plugin supports it, or EncodingJSON otherwise.
+func negotiate(client *rpc.Client, want string) (string, error) {
+	if want == "" || want == types.EncodingJSON {
+		return types.EncodingJSON, nil
+	}
+	var supported []string
+	if err := client.Call(RPCServerName+".Encodings", 0, &supported); err != nil {
+		return "", err
+	}
+	for _, e := range supported {
+		if e == want {
+			return want, nil
+		}
+	}
+	return types.EncodingJSON, nil
+}
+
+// summarize drops the Meta and PoolConfig of clients, which keeps the number and the heartbeats of clients of each resource.
+func summarize(pool types.ResourcePool) types.ResourcePool {
+	summary := types.ResourcePool{ID: pool.ID, Resources: make(map[string]types.Resource, len(pool.Resources))}
+	for id, res := range pool.Resources {
+		clients := make(map[string]types.Client, len(res.Clients))
+		for cid, c := range res.Clients {
+			clients[cid] = types.Client{ID: c.ID, Heartbeat: c.Heartbeat}
+		}
+		res.Clients = clients
+		summary.Resources[id] = res
+	}
+	return summary
+}
+
+// poolDeltas remembers the last pool sent to the plugin for each pool id, to send only the changed resources next time.
+type poolDeltas struct {
+	seq   uint64
+	mu    sync.Mutex
+	pools map[string]*poolRevision
+}
+
+// poolRevision is the pool of a revision, either sent by the client or received by the Server.
+type poolRevision struct {
+	mu        sync.Mutex
+	revision  uint64
+	resources map[string]types.Resource
+}
+
+func newPoolDeltas() *poolDeltas {
+	return &poolDeltas{pools: make(map[string]*poolRevision)}
+}
+
+// send calls with the delta of the pool, and resends the full pool if the plugin doesn't have the base revision.
+// Calls of the same pool are serialized to keep the revisions of both sides in step.
+func (d *poolDeltas) send(pool types.ResourcePool, call func(pool types.ResourcePool, delta *PoolDelta) error) error {
+	d.mu.Lock()
+	sp, ok := d.pools[pool.ID]
+	if !ok {
+		sp = &poolRevision{}
+		d.pools[pool.ID] = sp
+	}
+	d.mu.Unlock()
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	revision := atomic.AddUint64(&d.seq, 1)
+	var err error
+	if sp.revision != 0 {
+		changed := types.ResourcePool{ID: pool.ID, Resources: make(map[string]types.Resource)}
+		delta := &PoolDelta{Base: sp.revision, Revision: revision}
+		for id, res := range pool.Resources {
+			if prev, ok := sp.resources[id]; !ok || !reflect.DeepEqual(prev, res) {
+				changed.Resources[id] = res
+			}
+		}
+		for id := range sp.resources {
+			if _, ok := pool.Resources[id]; !ok {
+				delta.Removed = append(delta.Removed, id)
+			}
+		}
+		err = call(changed, delta)
+	}
+	if sp.revision == 0 || isServerError(err, PoolDeltaBaseErr) {
+		err = call(pool, &PoolDelta{Revision: revision})
+	}
+
+	sp.revision, sp.resources = 0, nil
+	if err == nil {
+		sp.revision, sp.resources = revision, make(map[string]types.Resource, len(pool.Resources))
+		for id, res := range pool.Resources {
+			sp.resources[id] = res
+		}
+	}
+	return err
+}
+
+// poolCache keeps the pools received by the Server to apply the deltas.
+type poolCache struct {
+	mu    sync.Mutex
+	pools map[string]*poolRevision
+}
+
+func (c *poolCache) apply(pool types.ResourcePool, delta *PoolDelta) (types.ResourcePool, error) {
+	if delta == nil {
+		return pool, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pools == nil {
+		c.pools = make(map[string]*poolRevision)
+	}
+
+	resources := make(map[string]types.Resource)
+	if delta.Base != 0 {
+		cached, ok := c.pools[pool.ID]
+		if !ok || cached.revision != delta.Base {
+			return pool, fmt.Errorf("fail to apply delta of pool %s on revision %d: %w", pool.ID, delta.Base, PoolDeltaBaseErr)
+		}
+		for id, res := range cached.resources {
+			resources[id] = res
+		}
+		for _, id := range delta.Removed {
+			delete(resources, id)
+		}
+	}
+	for id, res := range pool.Resources {
+		resources[id] = res
+	}
+	c.pools[pool.ID] = &poolRevision{revision: delta.Revision, resources: resources}
+
+	full := types.ResourcePool{ID: pool.ID, Resources: make(map[string]types.Resource, len(resources))}
+	for id, res := range resources {
+		full.Resources[id] = res
+	}
+	return full, nil
+}
+
+func isServerError(err error, target error) bool {
+	var serr rpc.ServerError
+	return errors.As(err, &serr) && strings.Contains(string(serr), target.Error())
+}
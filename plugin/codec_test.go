@@ -0,0 +1,244 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/types"
+)
+
+// poolController replies the first resource of the pool, and keeps the last pool it received.
+type poolController struct {
+	last types.ResourcePool
+}
+
+func (c *poolController) FindResource(pool types.ResourcePool, params map[string]interface{}) (types.Resource, error) {
+	c.last = pool
+	for _, res := range pool.Resources {
+		return res, nil
+	}
+	return types.Resource{ID: "new"}, nil
+}
+
+func (c *poolController) SyncResource(resource types.Resource, params map[string]interface{}) (types.Resource, error) {
+	return resource, nil
+}
+
+// pipeClient serves the controller by the Server through an in-memory connection.
+func pipeClient(controller types.Controller, encoding, view string) *rpcClient {
+	server := rpc.NewServer()
+	server.RegisterName(RPCServerName, &Server{controller: controller})
+	sc, cc := net.Pipe()
+	go server.ServeConn(sc)
+	client := &rpcClient{tracker: &tracker{}, client: rpc.NewClient(cc), encoding: encoding, view: view}
+	if view == types.PoolViewDelta {
+		client.deltas = newPoolDeltas()
+	}
+	return client
+}
+
+func largePool(resources, clients int) types.ResourcePool {
+	pool := types.ResourcePool{ID: "pool", Resources: make(map[string]types.Resource, resources)}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	for i := 0; i < resources; i++ {
+		res := types.Resource{
+			ID:          fmt.Sprintf("res-%d", i),
+			PoolID:      "pool",
+			State:       types.ResourceServing,
+			StateChange: now,
+			CreatedAt:   now,
+			Meta:        types.Meta{"zone": "us-east1-b", "ip": "10.0.0.1"},
+			Clients:     make(map[string]types.Client, clients),
+		}
+		for j := 0; j < clients; j++ {
+			id := fmt.Sprintf("client-%d-%d", i, j)
+			res.Clients[id] = types.Client{
+				ID:         id,
+				CreatedAt:  now,
+				Heartbeat:  now,
+				Meta:       types.Meta{"ip": "10.0.1.1", "user": "someone"},
+				PoolConfig: types.Meta{"size": "small"},
+			}
+		}
+		pool.Resources[res.ID] = res
+	}
+	return pool
+}
+
+var _ = Describe("Wire encoding", func() {
+	var controller *poolController
+	var pool types.ResourcePool
+
+	BeforeEach(func() {
+		controller = &poolController{}
+		pool = largePool(3, 2)
+	})
+
+	It("round trip args by msgpack", func() {
+		c := codecs[types.EncodingMsgpack]
+		data, err := c.marshal(&FindResourceArgs{Pool: pool, Params: map[string]interface{}{"a": "b", "n": 1.5}})
+		Expect(err).NotTo(HaveOccurred())
+		json, _ := codecs[types.EncodingJSON].marshal(&FindResourceArgs{Pool: pool, Params: map[string]interface{}{"a": "b", "n": 1.5}})
+		Expect(len(data)).To(BeNumerically("<", len(json)))
+
+		var args FindResourceArgs
+		Expect(c.unmarshal(data, &args)).NotTo(HaveOccurred())
+		Expect(args.Params).To(Equal(map[string]interface{}{"a": "b", "n": 1.5}))
+		Expect(args.Pool.Resources["res-1"].StateChange.Equal(pool.Resources["res-1"].StateChange)).To(BeTrue())
+		Expect(args.Pool.Resources["res-1"].Clients).To(HaveLen(2))
+	})
+
+	It("call with the negotiated encoding", func() {
+		client := pipeClient(controller, types.EncodingMsgpack, "")
+		defer client.client.Close()
+
+		encoding, err := negotiate(client.client, types.EncodingMsgpack)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(encoding).To(Equal(types.EncodingMsgpack))
+
+		res, err := client.FindResource(pool, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pool.Resources).To(HaveKey(res.ID))
+		Expect(controller.last.Resources).To(HaveLen(3))
+
+		_, err = client.ListExternal(pool, nil)
+		Expect(errors.Is(err, types.ExternalListNotSupportedErr)).To(BeTrue())
+	})
+
+	It("send the summary of pools", func() {
+		client := pipeClient(controller, types.EncodingJSON, types.PoolViewSummary)
+		defer client.client.Close()
+
+		_, err := client.FindResource(pool, nil)
+		Expect(err).NotTo(HaveOccurred())
+		for _, res := range controller.last.Resources {
+			Expect(res.Clients).To(HaveLen(2))
+			for _, c := range res.Clients {
+				Expect(c.Meta).To(BeNil())
+				Expect(c.PoolConfig).To(BeNil())
+				Expect(c.Heartbeat.IsZero()).To(BeFalse())
+			}
+		}
+	})
+
+	Describe("delta", func() {
+		var client *rpcClient
+		var sent []types.ResourcePool
+		var deltas []*PoolDelta
+
+		BeforeEach(func() {
+			client = pipeClient(controller, types.EncodingMsgpack, types.PoolViewDelta)
+			sent, deltas = nil, nil
+		})
+
+		AfterEach(func() {
+			client.client.Close()
+		})
+
+		send := func(pool types.ResourcePool) {
+			err := client.deltas.send(pool, func(p types.ResourcePool, d *PoolDelta) error {
+				sent, deltas = append(sent, p), append(deltas, d)
+				return client.call("FindResource", &FindResourceArgs{Pool: p, Delta: d}, &types.Resource{})
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(controller.last.Resources).To(HaveLen(len(pool.Resources)))
+			for id := range pool.Resources {
+				Expect(controller.last.Resources[id].State).To(Equal(pool.Resources[id].State))
+			}
+		}
+
+		It("send only the changed resources after the first call", func() {
+			send(pool)
+			Expect(sent[0].Resources).To(HaveLen(3))
+			Expect(deltas[0].Base).To(BeZero())
+
+			changed := pool.Resources["res-1"]
+			changed.State = types.ResourceDeleting
+			next := types.ResourcePool{ID: pool.ID, Resources: map[string]types.Resource{"res-0": pool.Resources["res-0"], "res-1": changed}}
+			send(next)
+			Expect(sent[1].Resources).To(HaveLen(1))
+			Expect(sent[1].Resources).To(HaveKey("res-1"))
+			Expect(deltas[1].Base).To(Equal(deltas[0].Revision))
+			Expect(deltas[1].Removed).To(Equal([]string{"res-2"}))
+		})
+
+		It("resend the full pool if the plugin lost the base", func() {
+			send(pool)
+			client.client.Close()
+			fresh := pipeClient(controller, types.EncodingMsgpack, types.PoolViewDelta)
+			defer fresh.client.Close()
+			client.client = fresh.client
+
+			send(pool)
+			Expect(sent).To(HaveLen(3))
+			Expect(sent[1].Resources).To(BeEmpty())
+			Expect(sent[2].Resources).To(HaveLen(3))
+			Expect(deltas[2].Base).To(BeZero())
+		})
+	})
+
+	Describe("Launcher", func() {
+		It("negotiate the encoding with the plugin", func() {
+			launcher := NewLauncher(types.CmdParam{Name: "puppet", Path: "./mock/server/puppet", Encoding: types.EncodingMsgpack, PoolView: types.PoolViewDelta}, nil)
+			defer launcher.Close()
+			controller, err := launcher.Launch()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(controller.(*observed).next.(*rpcClient).encoding).To(Equal(types.EncodingMsgpack))
+
+			for i := 0; i < 2; i++ {
+				res, err := controller.FindResource(pool, map[string]interface{}{"ret": "res-2"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(res.ID).To(Equal("res-2"))
+			}
+		})
+
+		It("refuse unknown encodings", func() {
+			launcher := NewLauncher(types.CmdParam{Name: "puppet", Path: "./mock/server/puppet", Encoding: "xml"}, nil)
+			defer launcher.Close()
+			_, err := launcher.Launch()
+			Expect(errors.Is(err, UnknownEncodingErr)).To(BeTrue())
+		})
+	})
+})
+
+func benchmarkFindResource(b *testing.B, encoding, view string, resources int) {
+	controller := &poolController{}
+	client := pipeClient(controller, encoding, view)
+	defer client.client.Close()
+	pool := largePool(resources, 10)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.FindResource(pool, map[string]interface{}{"size": "small"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkFindResource compares the wire encodings and pool views with large pools, where the v1 is the json per method rpc.
+func BenchmarkFindResource(b *testing.B) {
+	for _, resources := range []int{100, 1000} {
+		for _, c := range []struct{ encoding, view string }{
+			{"", types.PoolViewFull},
+			{types.EncodingJSON, types.PoolViewFull},
+			{types.EncodingMsgpack, types.PoolViewFull},
+			{types.EncodingMsgpack, types.PoolViewSummary},
+			{types.EncodingMsgpack, types.PoolViewDelta},
+		} {
+			encoding := c.encoding
+			if encoding == "" {
+				encoding = "v1"
+			}
+			b.Run(fmt.Sprintf("%s/%s/%d", encoding, c.view, resources), func(b *testing.B) {
+				benchmarkFindResource(b, c.encoding, c.view, resources)
+			})
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rueian/godemand/types"
+)
+
+const DefaultExecTimeout = 30 * time.Second
+
+// ExecNotSupportedStatus is the exit status of a script which doesn't implement the method, such as ListExternal.
+const ExecNotSupportedStatus = 64
+
+var ExecTimeoutErr = errors.New("plugin script doesn't exit in time")
+
+// ExecError is returned when the script exits with a non zero status, with its last stderr lines.
+// Err is either the ExecTimeoutErr or the *exec.ExitError of the script.
+type ExecError struct {
+	Name       string
+	Method     string
+	ExitStatus string
+	Stderr     []string
+	Err        error
+}
+
+func (e *ExecError) Error() string {
+	msg := fmt.Sprintf("fail to execute %s of plugin %s: %s", e.Method, e.Name, e.ExitStatus)
+	if len(e.Stderr) > 0 {
+		msg += ", stderr:\n" + strings.Join(e.Stderr, "\n")
+	}
+	return msg
+}
+
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
+// ExecController implements types.Controller by executing the plugin binary for each call,
+// with its args followed by the method name, writing the json args to its stdin, and decoding its stdout as the result.
+// The binary is verified again against the pinned sha256 and owners whenever it is changed on disk.
+type ExecController struct {
+	param   types.CmdParam
+	sink    *LogSink
+	timeout time.Duration
+	mu      sync.Mutex
+	binary  binary
+}
+
+func NewExecController(param types.CmdParam, sink *LogSink) (*ExecController, error) {
+	bin, file, err := openBinary(param.Path)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	if err := bin.verify(param); err != nil {
+		return nil, err
+	}
+	if sink == nil {
+		if sink, err = NewLogSink(param.Name, param.Logs, nil); err != nil {
+			return nil, err
+		}
+	}
+	timeout := param.Exec.Timeout
+	if timeout <= 0 {
+		timeout = DefaultExecTimeout
+	}
+	return &ExecController{param: param, sink: sink, timeout: timeout, binary: bin}, nil
+}
+
+func (c *ExecController) FindResource(pool types.ResourcePool, params map[string]interface{}) (res types.Resource, err error) {
+	err = c.run("FindResource", &FindResourceArgs{Pool: pool, Params: params}, &res)
+	return
+}
+
+func (c *ExecController) SyncResource(resource types.Resource, params map[string]interface{}) (res types.Resource, err error) {
+	err = c.run("SyncResource", &SyncResourceArgs{Resource: resource, Params: params}, &res)
+	return
+}
+
+func (c *ExecController) ListExternal(pool types.ResourcePool, params map[string]interface{}) (res []types.Resource, err error) {
+	err = c.run("ListExternal", &ListExternalArgs{Pool: pool, Params: params}, &res)
+	var xerr *exec.ExitError
+	if errors.As(err, &xerr) && xerr.ExitCode() == ExecNotSupportedStatus {
+		err = fmt.Errorf("fail to list external resources: %w", types.ExternalListNotSupportedErr)
+	}
+	return
+}
+
+func (c *ExecController) run(method string, args, reply interface{}) error {
+	bin, file, err := c.verified()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	input, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, bin.Path, append(append([]string(nil), c.param.Args...), method)...)
+	pinExec(cmd, file)
+	cmd.Env = environ(c.param)
+	cmd.Dir = c.param.Dir
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// do not wait for children holding the output pipes after the script is killed
+	cmd.WaitDelay = time.Second
+	if cmd.SysProcAttr, err = sysProcAttr(c.param); err != nil {
+		return err
+	}
+	limitExec(cmd, c.param.Rlimits)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	err = cmd.Wait()
+
+	var lines []string
+	scanner := bufio.NewScanner(&stderr)
+	for scanner.Scan() {
+		c.sink.Write(cmd.Process.Pid, "stderr", scanner.Text())
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) > LaunchDiagnosticLines {
+		lines = lines[len(lines)-LaunchDiagnosticLines:]
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return &ExecError{Name: c.param.Name, Method: method, ExitStatus: fmt.Sprintf("killed after %s", c.timeout), Stderr: lines, Err: ExecTimeoutErr}
+	}
+	if err != nil {
+		return &ExecError{Name: c.param.Name, Method: method, ExitStatus: cmd.ProcessState.String(), Stderr: lines, Err: err}
+	}
+	if err := json.Unmarshal(stdout.Bytes(), reply); err != nil {
+		return fmt.Errorf("fail to decode the output of %s of plugin %s: %w", method, c.param.Name, err)
+	}
+	return nil
+}
+
+// verified opens the binary for a call, which is verified again if it is changed since the last call.
+func (c *ExecController) verified() (binary, *os.File, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	file, err := os.Open(c.binary.Path)
+	if err != nil {
+		return binary{}, nil, err
+	}
+	bin, err := snapshot(file, c.binary)
+	if err == nil && bin != c.binary {
+		err = bin.verify(c.param)
+	}
+	if err != nil {
+		file.Close()
+		return binary{}, nil, err
+	}
+	c.binary = bin
+	return bin, file, nil
+}
@@ -0,0 +1,117 @@
+package plugin
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/types"
+)
+
+const execScript = `#!/bin/sh
+input=$(cat)
+case "$2" in
+FindResource)
+  echo "{\"ID\":\"found\",\"Meta\":{\"flag\":\"$1\",\"args\":$input}}" ;;
+SyncResource)
+  echo "syncing" >&2
+  echo "$input" | grep -q '"ID":"bad"' && { echo "bad resource" >&2; exit 2; }
+  echo '{"ID":"synced"}' ;;
+ListExternal)
+  exit 64 ;;
+esac
+`
+
+var _ = Describe("ExecController", func() {
+	var dir string
+	var param types.CmdParam
+	var controller *ExecController
+	var err error
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "exec")
+		Expect(ioutil.WriteFile(filepath.Join(dir, "script"), []byte(execScript), 0755)).NotTo(HaveOccurred())
+		param = types.CmdParam{Name: "script", Path: filepath.Join(dir, "script"), Args: []string{"-v"}, Kind: types.KindExec}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	JustBeforeEach(func() {
+		controller, err = NewExecController(param, nil)
+	})
+
+	It("pass the method as the last arg and json args on stdin", func() {
+		Expect(err).NotTo(HaveOccurred())
+		res, err := controller.FindResource(types.ResourcePool{ID: "pool1"}, map[string]interface{}{"a": "b"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res.ID).To(Equal("found"))
+		Expect(res.Meta).To(HaveKeyWithValue("flag", "-v"))
+		Expect(res.Meta["args"]).To(HaveKeyWithValue("Params", map[string]interface{}{"a": "b"}))
+	})
+
+	It("report non zero exit with stderr", func() {
+		_, err := controller.SyncResource(types.Resource{ID: "bad"}, nil)
+		var eerr *ExecError
+		Expect(errors.As(err, &eerr)).To(BeTrue())
+		Expect(eerr.ExitStatus).To(Equal("exit status 2"))
+		var xerr *exec.ExitError
+		Expect(errors.As(err, &xerr)).To(BeTrue())
+		Expect(xerr.ExitCode()).To(Equal(2))
+		Expect(eerr.Stderr).To(Equal([]string{"syncing", "bad resource"}))
+		Expect(err.Error()).To(ContainSubstring("bad resource"))
+		Expect(controller.sink.Lines()).To(HaveLen(2))
+	})
+
+	It("map the not supported status of ListExternal", func() {
+		_, err := controller.ListExternal(types.ResourcePool{ID: "pool1"}, nil)
+		Expect(errors.Is(err, types.ExternalListNotSupportedErr)).To(BeTrue())
+	})
+
+	Context("with slow script", func() {
+		BeforeEach(func() {
+			param.Path = "/bin/sh"
+			param.Args = []string{"-c", "sleep 10", "sh"}
+			param.Exec.Timeout = 100 * time.Millisecond
+		})
+		It("kill it after timeout", func() {
+			start := time.Now()
+			_, err := controller.SyncResource(types.Resource{ID: "a"}, nil)
+			Expect(errors.Is(err, ExecTimeoutErr)).To(BeTrue())
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		})
+	})
+
+	Context("with pinned checksum", func() {
+		BeforeEach(func() {
+			param.SHA256, _ = checksum(param.Path)
+		})
+		It("refuse the script changed on disk", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(param.Path, []byte(execScript+"\n"), 0755)).NotTo(HaveOccurred())
+			_, err := controller.SyncResource(types.Resource{ID: "a"}, nil)
+			Expect(errors.Is(err, ChecksumMismatchErr)).To(BeTrue())
+		})
+	})
+
+	Describe("Launchpad", func() {
+		It("serve the script as a plugin", func() {
+			launchpad := NewLaunchpad()
+			defer launchpad.Close()
+			Expect(launchpad.SetLaunchers(map[string]types.CmdParam{"script": param})).NotTo(HaveOccurred())
+
+			c, err := launchpad.GetController("script")
+			Expect(err).NotTo(HaveOccurred())
+			res, err := c.SyncResource(types.Resource{ID: "a"}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.ID).To(Equal("synced"))
+			Expect(launchpad.Status()[0].Logs[0].Text).To(Equal("syncing"))
+		})
+	})
+})
@@ -11,22 +11,56 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/rueian/godemand/metrics"
 	"github.com/rueian/godemand/types"
+	"go.opencensus.io/trace"
+	"go.opencensus.io/trace/propagation"
 )
 
-const CurrentProtocolVersion = 1
+const CurrentProtocolVersion = 2
 const RPCServerName = "Controller"
+const DefaultLaunchTimeout = 30 * time.Second
 
 var MinimumProtocolVersion = 1
 
+// LaunchDiagnosticLines is the number of recent output lines attached to a LaunchError.
+var LaunchDiagnosticLines = 20
+
 var (
-	ProtocolVersionTooOldErr = errors.New("plugin's protocol version is too old")
-	LaunchTimeoutErr         = errors.New("plugin doesn't print its port in time")
-	MalformedLaunchSignError = errors.New("plugin prints a malformed sign")
+	ProtocolVersionTooOldErr   = errors.New("plugin's protocol version is too old")
+	LaunchTimeoutErr           = errors.New("plugin doesn't print its port in time")
+	MalformedLaunchSignError   = errors.New("plugin prints a malformed sign")
+	ProcessAttrNotSupportedErr = errors.New("plugin process attribute not supported on this platform")
 )
 
+// LaunchError is returned by Launcher.Launch when the plugin is started but fails to serve,
+// with its recent stdout and stderr lines, and its exit status if it already died.
+type LaunchError struct {
+	Name       string
+	Err        error
+	Output     []string
+	ExitStatus string
+}
+
+func (e *LaunchError) Error() string {
+	msg := fmt.Sprintf("fail to launch plugin %s: %s", e.Name, e.Err)
+	if e.ExitStatus != "" {
+		msg += ", " + e.ExitStatus
+	}
+	if len(e.Output) > 0 {
+		msg += ", last output:\n" + strings.Join(e.Output, "\n")
+	}
+	return msg
+}
+
+func (e *LaunchError) Unwrap() error {
+	return e.Err
+}
+
 func NewLauncher(param types.CmdParam, logger *log.Logger) *Launcher {
 	if logger == nil {
 		logger = log.New(os.Stderr, "", log.LstdFlags)
@@ -37,11 +71,20 @@ func NewLauncher(param types.CmdParam, logger *log.Logger) *Launcher {
 type Launcher struct {
 	CmdParam   types.CmdParam
 	Controller types.Controller
+	Sink       *LogSink
 	command    *exec.Cmd
 	client     *rpc.Client
+	calls      *tracker
+	local      *localClient
 	cancel     context.CancelFunc
 	logger     *log.Logger
 	doneCh     chan error
+	exited     chan struct{}
+	binary     binary
+	disk       binary
+	ownSink    bool
+	startedAt  time.Time
+	version    int
 	err        error
 }
 
@@ -49,8 +92,42 @@ func (l *Launcher) Launch() (types.Controller, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	l.cancel = cancel
 
-	cmd := exec.CommandContext(ctx, l.CmdParam.Path)
-	cmd.Env = append(os.Environ(), l.CmdParam.Envs...)
+	if l.Sink == nil {
+		sink, err := NewLogSink(l.CmdParam.Name, l.CmdParam.Logs, l.logger)
+		if err != nil {
+			return nil, err
+		}
+		l.Sink, l.ownSink = sink, true
+	}
+
+	if controller, ok, err := l.localController(); ok {
+		if err != nil {
+			return nil, err
+		}
+		return l.launchLocal(ctx, controller)
+	}
+
+	if err := validWire(l.CmdParam); err != nil {
+		return nil, err
+	}
+	bin, file, err := openBinary(l.CmdParam.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if err := bin.verify(l.CmdParam); err != nil {
+		return nil, err
+	}
+	l.binary, l.disk = bin, bin
+
+	cmd := exec.CommandContext(ctx, bin.Path, l.CmdParam.Args...)
+	pinExec(cmd, file)
+	cmd.Env = environ(l.CmdParam)
+	cmd.Dir = l.CmdParam.Dir
+	if cmd.SysProcAttr, err = sysProcAttr(l.CmdParam); err != nil {
+		return nil, err
+	}
+	limitExec(cmd, l.CmdParam.Rlimits)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -65,67 +142,159 @@ func (l *Launcher) Launch() (types.Controller, error) {
 		return nil, err
 	}
 	l.command = cmd
+	l.startedAt = time.Now()
 	l.doneCh = make(chan error, 1)
-	listenCh := make(chan string)
+	l.exited = make(chan struct{})
+	pid := cmd.Process.Pid
+
+	var pipes sync.WaitGroup
+	pipes.Add(2)
+	listenCh := make(chan string, 1)
 	go func() {
+		defer pipes.Done()
+		listened := false
 		scanner := bufio.NewScanner(stdout)
 		for scanner.Scan() {
-			l.logger.Println(l.CmdParam.Name + " stdout: " + scanner.Text())
-			if strings.HasPrefix(scanner.Text(), ListenedSign) {
+			l.Sink.Write(pid, "stdout", scanner.Text())
+			if !listened && strings.HasPrefix(scanner.Text(), ListenedSign) {
 				listenCh <- scanner.Text()
-				close(listenCh)
+				listened = true
 			}
 		}
 		if scanner.Err() != nil {
-			l.logger.Println(l.CmdParam.Name + " stdout: " + scanner.Err().Error())
+			l.Sink.Write(pid, "stdout", scanner.Err().Error())
 		}
 	}()
 	go func() {
+		defer pipes.Done()
 		scanner := bufio.NewScanner(stderr)
 		for scanner.Scan() {
-			l.logger.Println(l.CmdParam.Name + " stderr: " + scanner.Text())
+			l.Sink.Write(pid, "stderr", scanner.Text())
 		}
 		if scanner.Err() != nil {
-			l.logger.Println(l.CmdParam.Name + " stderr: " + scanner.Err().Error())
+			l.Sink.Write(pid, "stderr", scanner.Err().Error())
 		}
 	}()
 	go func() {
+		pipes.Wait()
 		if err := cmd.Wait(); err != nil {
 			l.logger.Println(l.CmdParam.Name + ": " + err.Error())
 			l.doneCh <- err
 		}
 		close(l.doneCh)
+		close(l.exited)
 	}()
 
+	timeout := l.CmdParam.LaunchTimeout
+	if timeout <= 0 {
+		timeout = DefaultLaunchTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
 	var network, address, version string
 	select {
 	case sign := <-listenCh:
 		s := strings.Split(sign, "|")
 		if len(s) != 4 {
-			cancel()
-			return nil, fmt.Errorf("fail to parse sign %q: %w", sign, MalformedLaunchSignError)
+			return nil, l.fail(fmt.Errorf("fail to parse sign %q: %w", sign, MalformedLaunchSignError))
 		}
 		version, network, address = s[1], s[2], s[3]
-	case <-time.Tick(30 * time.Second):
-		cancel()
-		return nil, fmt.Errorf("fail to connect plugin in 30 sec: %w", LaunchTimeoutErr)
+	case <-l.exited:
+		return nil, l.fail(fmt.Errorf("plugin exited before printing its port: %w", LaunchTimeoutErr))
+	case <-timer.C:
+		return nil, l.fail(fmt.Errorf("fail to connect plugin in %s: %w", timeout, LaunchTimeoutErr))
 	}
 
-	if v, err := strconv.Atoi(version); err != nil || v < MinimumProtocolVersion {
-		cancel()
-		return nil, fmt.Errorf("fail to load the plugin %s: %w", l.CmdParam.Name, ProtocolVersionTooOldErr)
+	if l.version, err = strconv.Atoi(version); err != nil || l.version < MinimumProtocolVersion {
+		return nil, l.fail(fmt.Errorf("fail to load the plugin %s: %w", l.CmdParam.Name, ProtocolVersionTooOldErr))
 	}
 
 	l.client, err = rpc.Dial(network, address)
 	if err != nil {
-		cancel()
-		return nil, err
+		return nil, l.fail(err)
 	}
 
-	l.Controller = &rpcClient{client: l.client}
+	client := &rpcClient{client: l.client, tracker: &tracker{}, view: l.CmdParam.PoolView}
+	if l.version >= 2 {
+		if client.encoding, err = negotiate(l.client, l.CmdParam.Encoding); err != nil {
+			return nil, l.fail(err)
+		}
+		if client.view == types.PoolViewDelta {
+			client.deltas = newPoolDeltas()
+		}
+	}
+	l.calls = client.tracker
+	l.Controller = &observed{name: l.CmdParam.Name, next: client, outcome: rpcOutcome}
 	return l.Controller, nil
 }
 
+// fail kills the plugin which is failed to launch, and attaches its recent output and exit status to the err.
+func (l *Launcher) fail(err error) error {
+	lerr := &LaunchError{Name: l.CmdParam.Name, Err: err}
+	select {
+	case <-l.exited:
+		lerr.ExitStatus = l.command.ProcessState.String()
+	default:
+		l.cancel()
+		wait(l.exited, time.Second)
+	}
+	lerr.Output = l.Sink.Tail(l.command.Process.Pid, LaunchDiagnosticLines)
+	return lerr
+}
+
+// Checksum returns the sha256 of the launched plugin binary in hex.
+func (l *Launcher) Checksum() string {
+	return l.binary.Checksum
+}
+
+// Ping checks if the launched plugin is able to serve rpc calls.
+func (l *Launcher) Ping() error {
+	if l.local != nil {
+		return l.local.alive()
+	}
+	if l.client == nil {
+		return fmt.Errorf("fail to ping the plugin %s: %w", l.CmdParam.Name, rpc.ErrShutdown)
+	}
+	var version int
+	return l.client.Call(RPCServerName+".ProtocolVersion", 0, &version)
+}
+
+// Shutdown waits outstanding calls to be drained and then terminates the plugin gracefully by SIGTERM.
+// The plugin will be killed if any of the two steps doesn't finish in time.
+func (l *Launcher) Shutdown(timeout time.Duration) {
+	if l.calls != nil {
+		wait(l.calls.drain(), timeout)
+	}
+	if l.command != nil && l.command.Process != nil {
+		if err := l.command.Process.Signal(syscall.SIGTERM); err == nil {
+			wait(l.exited, timeout)
+		}
+	}
+	l.Close()
+}
+
+func (l *Launcher) pid() int {
+	if l.command == nil || l.command.Process == nil {
+		return 0
+	}
+	return l.command.Process.Pid
+}
+
+func environ(param types.CmdParam) []string {
+	var env []string
+	if param.CleanEnv {
+		for _, k := range param.EnvAllowlist {
+			if v, ok := os.LookupEnv(k); ok {
+				env = append(env, k+"="+v)
+			}
+		}
+	} else {
+		env = os.Environ()
+	}
+	return append(env, param.Envs...)
+}
+
 func (l *Launcher) Err() error {
 	for {
 		err, more := <-l.doneCh
@@ -146,18 +315,167 @@ func (l *Launcher) Close() {
 	if l.cancel != nil {
 		l.cancel()
 	}
+	if l.ownSink && l.Sink != nil {
+		l.Sink.Close()
+	}
+}
+
+func wait(ch <-chan struct{}, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return true
+	case <-timer.C:
+		return false
+	}
 }
 
+// rpcClient calls the plugin by the negotiated encoding since protocol version 2, or by json otherwise.
 type rpcClient struct {
-	client *rpc.Client
+	*tracker
+	client   *rpc.Client
+	encoding string
+	view     string
+	deltas   *poolDeltas
+}
+
+func (c *rpcClient) FindResource(pool types.ResourcePool, params map[string]interface{}) (types.Resource, error) {
+	return c.FindResourceContext(context.Background(), pool, params)
+}
+
+func (c *rpcClient) SyncResource(resource types.Resource, params map[string]interface{}) (types.Resource, error) {
+	return c.SyncResourceContext(context.Background(), resource, params)
 }
 
-func (c *rpcClient) FindResource(pool types.ResourcePool, params map[string]interface{}) (res types.Resource, err error) {
-	err = call(c.client, RPCServerName+".FindResource", &FindResourceArgs{Pool: pool, Params: params}, &res)
+func (c *rpcClient) FindResourceContext(ctx context.Context, pool types.ResourcePool, params map[string]interface{}) (res types.Resource, err error) {
+	c.begin()
+	defer c.end()
+	parent := traceOf(ctx)
+	err = c.withPool(pool, func(pool types.ResourcePool, delta *PoolDelta) error {
+		return c.call("FindResource", &FindResourceArgs{Pool: pool, Params: params, Delta: delta, Trace: parent}, &res)
+	})
 	return
 }
 
-func (c *rpcClient) SyncResource(resource types.Resource, params map[string]interface{}) (res types.Resource, err error) {
-	err = call(c.client, RPCServerName+".SyncResource", &SyncResourceArgs{Resource: resource, Params: params}, &res)
+func (c *rpcClient) SyncResourceContext(ctx context.Context, resource types.Resource, params map[string]interface{}) (res types.Resource, err error) {
+	c.begin()
+	defer c.end()
+	err = c.call("SyncResource", &SyncResourceArgs{Resource: resource, Params: params, Trace: traceOf(ctx)}, &res)
+	return
+}
+
+func (c *rpcClient) ListExternal(pool types.ResourcePool, params map[string]interface{}) (res []types.Resource, err error) {
+	c.begin()
+	defer c.end()
+	err = c.withPool(pool, func(pool types.ResourcePool, delta *PoolDelta) error {
+		return c.call("ListExternal", &ListExternalArgs{Pool: pool, Params: params, Delta: delta}, &res)
+	})
+	if err != nil && notSupported(err) {
+		err = fmt.Errorf("fail to list external resources: %w", types.ExternalListNotSupportedErr)
+	}
 	return
 }
+
+// traceOf returns the span context in the ctx in the binary propagation format, or nil if the ctx is not traced.
+func traceOf(ctx context.Context) []byte {
+	if span := trace.FromContext(ctx); span != nil {
+		return propagation.Binary(span.SpanContext())
+	}
+	return nil
+}
+
+// rpcOutcome tells the errors returned by the controller of the plugin from the failures of the rpc.
+func rpcOutcome(err error) string {
+	var serr rpc.ServerError
+	switch {
+	case err == nil:
+		return metrics.OutcomeOK
+	case errors.As(err, &serr), errors.Is(err, types.ExternalListNotSupportedErr):
+		return metrics.OutcomeError
+	}
+	return metrics.OutcomeUnavailable
+}
+
+// withPool sends the pool by the view of the client.
+func (c *rpcClient) withPool(pool types.ResourcePool, send func(pool types.ResourcePool, delta *PoolDelta) error) error {
+	switch {
+	case c.view == types.PoolViewSummary:
+		return send(summarize(pool), nil)
+	case c.deltas != nil:
+		return c.deltas.send(pool, send)
+	}
+	return send(pool, nil)
+}
+
+func (c *rpcClient) call(method string, args, reply interface{}) error {
+	codec, ok := codecs[c.encoding]
+	if !ok {
+		return call(c.client, RPCServerName+"."+method, args, reply)
+	}
+	data, err := codec.marshal(args)
+	if err != nil {
+		return err
+	}
+	var out []byte
+	if err := c.client.Call(RPCServerName+".Call", &Request{Method: method, Encoding: c.encoding, Args: data}, &out); err != nil {
+		return err
+	}
+	return codec.unmarshal(out, reply)
+}
+
+// tracker counts the outstanding calls of a controller.
+type tracker struct {
+	mu       sync.Mutex
+	inflight int
+	idle     chan struct{}
+}
+
+func (c *tracker) begin() {
+	c.mu.Lock()
+	c.inflight++
+	c.mu.Unlock()
+}
+
+func (c *tracker) end() {
+	c.mu.Lock()
+	c.inflight--
+	if c.inflight == 0 && c.idle != nil {
+		close(c.idle)
+		c.idle = nil
+	}
+	c.mu.Unlock()
+}
+
+// load returns the number of outstanding calls.
+func (c *tracker) load() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.inflight
+}
+
+// drain returns a channel which will be closed once there is no outstanding call.
+func (c *tracker) drain() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.idle != nil {
+		return c.idle
+	}
+	ch := make(chan struct{})
+	if c.inflight == 0 {
+		close(ch)
+	} else {
+		c.idle = ch
+	}
+	return ch
+}
+
+// notSupported reports whether the rpc error is caused by a plugin that doesn't implement the method,
+// either because its controller doesn't or because it is built with an older version of this package.
+func notSupported(err error) bool {
+	var serr rpc.ServerError
+	if !errors.As(err, &serr) {
+		return false
+	}
+	return string(serr) == types.ExternalListNotSupportedErr.Error() || strings.HasPrefix(string(serr), "rpc: can't find method")
+}
@@ -7,9 +7,14 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/rueian/godemand/types"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 var _ = Describe("PluginLauncher", func() {
@@ -65,9 +70,159 @@ var _ = Describe("PluginLauncher", func() {
 		})
 	})
 
+	Context("with plugin not printing its sign in time", func() {
+		BeforeEach(func() {
+			cmdParam.Path = "/bin/sh"
+			cmdParam.Args = []string{"-c", "echo booting; sleep 10"}
+			cmdParam.LaunchTimeout = 200 * time.Millisecond
+		})
+
+		It("fail with LaunchError", func() {
+			var lerr *LaunchError
+			Expect(errors.As(err, &lerr)).To(BeTrue())
+			Expect(errors.Is(err, LaunchTimeoutErr)).To(BeTrue())
+			Expect(lerr.Output).To(Equal([]string{"stdout: booting"}))
+			Expect(lerr.ExitStatus).To(BeEmpty())
+		})
+	})
+
+	Context("with plugin exited before printing its sign", func() {
+		BeforeEach(func() {
+			cmdParam.Path = "/bin/sh"
+			cmdParam.Args = []string{"-c", "echo booting; echo crashed >&2; exit 3"}
+		})
+
+		It("fail with LaunchError immediately", func() {
+			var lerr *LaunchError
+			Expect(errors.As(err, &lerr)).To(BeTrue())
+			Expect(lerr.Output).To(ConsistOf("stdout: booting", "stderr: crashed"))
+			Expect(lerr.ExitStatus).To(Equal("exit status 3"))
+			Expect(err.Error()).To(ContainSubstring("crashed"))
+		})
+	})
+
+	Context("with log file", func() {
+		var dir string
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "logs")
+			cmdParam.Path = "/bin/sh"
+			cmdParam.Args = []string{"-c", `echo '{"level":"warn","msg":"booting"}'; exit 3`}
+			cmdParam.Logs = types.LogConfig{File: filepath.Join(dir, "plugin.log"), JSON: true}
+		})
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("write lines to the file instead of the logger", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(buf.String()).NotTo(ContainSubstring("booting"))
+
+			content, _ := ioutil.ReadFile(cmdParam.Logs.File)
+			Expect(string(content)).To(ContainSubstring(`stdout: {"level":"warn","msg":"booting"}`))
+
+			lines := launcher.Sink.Lines()
+			Expect(lines).To(HaveLen(1))
+			Expect(lines[0].PID).To(Equal(launcher.command.Process.Pid))
+			Expect(lines[0].Fields).To(HaveKeyWithValue("level", "warn"))
+		})
+	})
+
+	Context("with clean env", func() {
+		BeforeEach(func() {
+			os.Setenv("ALLOWED_ENV", "1")
+			os.Setenv("HIDDEN_ENV", "1")
+			cmdParam.CleanEnv = true
+			cmdParam.EnvAllowlist = []string{"ALLOWED_ENV"}
+			cmdParam.Envs = []string{"CUSTOM_1=1"}
+		})
+		AfterEach(func() {
+			os.Unsetenv("ALLOWED_ENV")
+			os.Unsetenv("HIDDEN_ENV")
+		})
+
+		It("only pass allowed and custom envs", func() {
+			Expect(err).NotTo(HaveOccurred())
+
+			scanner := bufio.NewScanner(buf)
+			scanner.Scan()
+			line := scanner.Text()
+
+			Expect(line).To(ContainSubstring("ALLOWED_ENV=1"))
+			Expect(line).To(ContainSubstring("CUSTOM_1=1"))
+			Expect(line).NotTo(ContainSubstring("HIDDEN_ENV"))
+		})
+	})
+
+	Context("with args and dir", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "launcher")
+			dir, _ = filepath.EvalSymlinks(dir)
+			cmdParam.Args = []string{"-a", "b"}
+			cmdParam.Dir = dir
+		})
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("pass args and run in dir", func() {
+			Expect(err).NotTo(HaveOccurred())
+
+			scanner := bufio.NewScanner(buf)
+			scanner.Scan()
+			scanner.Scan()
+			Expect(scanner.Text()).To(ContainSubstring("args: -a b dir: " + dir))
+		})
+	})
+
+	Context("with pinned checksum", func() {
+		BeforeEach(func() {
+			cmdParam.SHA256, _ = checksum(cmdParam.Path)
+		})
+
+		It("launched", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(launcher.Checksum()).To(Equal(cmdParam.SHA256))
+		})
+	})
+
+	Context("with mismatched checksum", func() {
+		BeforeEach(func() {
+			cmdParam.SHA256 = "mismatched"
+		})
+
+		It("reject with VerificationError", func() {
+			var verr *VerificationError
+			Expect(errors.As(err, &verr)).To(BeTrue())
+			Expect(verr.Expected).To(Equal("mismatched"))
+			Expect(errors.Is(err, ChecksumMismatchErr)).To(BeTrue())
+		})
+	})
+
+	Context("with allowed owner", func() {
+		BeforeEach(func() {
+			cmdParam.Owners = []string{strconv.Itoa(os.Getuid())}
+		})
+
+		It("launched", func() {
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("with not allowed owner", func() {
+		BeforeEach(func() {
+			cmdParam.Owners = []string{"nobody-in-particular"}
+		})
+
+		It("reject with VerificationError", func() {
+			Expect(errors.Is(err, OwnerNotAllowedErr)).To(BeTrue())
+		})
+	})
+
 	Context("with non supported protocol version", func() {
 		BeforeEach(func() {
-			MinimumProtocolVersion = 2 // temporary make it higher
+			MinimumProtocolVersion = CurrentProtocolVersion + 1 // temporary make it higher
 		})
 		AfterEach(func() {
 			MinimumProtocolVersion = 1 // change it back
@@ -105,6 +260,23 @@ var _ = Describe("PluginLauncher", func() {
 		})
 	})
 
+	Context("with health check", func() {
+		It("ping the plugin", func() {
+			Expect(launcher.Ping()).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("with shutdown", func() {
+		JustBeforeEach(func() {
+			launcher.Shutdown(time.Second)
+		})
+
+		It("terminate the plugin", func() {
+			Expect(launcher.exited).To(BeClosed())
+			Expect(launcher.Ping()).To(HaveOccurred())
+		})
+	})
+
 	Context("with plugin terminated", func() {
 		JustBeforeEach(func() {
 			launcher.command.Process.Signal(syscall.SIGINT)
@@ -1,15 +1,23 @@
 package plugin
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/rueian/godemand/metrics"
 	"github.com/rueian/godemand/types"
 )
 
-var ControllerNotFoundErr = errors.New("controller not found in launchpad")
+var (
+	ControllerNotFoundErr = errors.New("controller not found in launchpad")
+	PluginNotFoundErr     = errors.New("plugin not found in launchpad")
+)
 
 type Errors struct {
 	errs []error
@@ -31,93 +39,547 @@ func (e *Errors) Len() int {
 	return len(e.errs)
 }
 
-func NewLaunchpad() *Launchpad {
-	return &Launchpad{
-		launchers: make(map[string]*Launcher),
+type LaunchpadOptionFunc func(*Launchpad)
+
+// WithDrainTimeout sets how long a replaced or removed plugin is given to finish its outstanding calls,
+// and then how long it is given to exit after SIGTERM before being killed.
+func WithDrainTimeout(timeout time.Duration) LaunchpadOptionFunc {
+	return func(launchpad *Launchpad) {
+		launchpad.drainTimeout = timeout
+	}
+}
+
+// WithWatchInterval enables polling plugin binaries on disk, and a plugin will be relaunched once its binary is changed.
+func WithWatchInterval(interval time.Duration) LaunchpadOptionFunc {
+	return func(launchpad *Launchpad) {
+		launchpad.watchInterval = interval
 	}
 }
 
+// WithEventDAO makes the launchpad record plugin events to the pools served by the plugin.
+func WithEventDAO(dao types.ResourceDAO) LaunchpadOptionFunc {
+	return func(launchpad *Launchpad) {
+		launchpad.events = dao
+	}
+}
+
+func NewLaunchpad(options ...LaunchpadOptionFunc) *Launchpad {
+	p := &Launchpad{
+		launchers:    make(map[string][]*Launcher),
+		states:       make(map[string]*pluginState),
+		drainTimeout: 10 * time.Second,
+	}
+
+	for _, of := range options {
+		of(p)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	if p.watchInterval > 0 {
+		go p.watchBinaries(ctx)
+	}
+
+	return p
+}
+
 type Launchpad struct {
-	launchers map[string]*Launcher
-	mu        sync.Mutex
+	// launchers are the running instances of each plugin indexed by their slot, nil for a slot not running
+	launchers     map[string][]*Launcher
+	states        map[string]*pluginState
+	drainTimeout  time.Duration
+	watchInterval time.Duration
+	events        types.ResourceDAO
+	cancel        context.CancelFunc
+	mu            sync.Mutex
+	// switching serializes the launching and switching of plugins between SetLaunchers, Restart, Stop and the binary watcher
+	switching sync.Mutex
+}
+
+// pluginState is what the launchpad remembers about a plugin set by SetLaunchers, whether it is running or not.
+type pluginState struct {
+	param    types.CmdParam
+	err      error
+	rejected string // checksum of the binary failed to reload
+	launches []int  // number of launches of each slot
+	stopped  bool
+	sink     *LogSink // shared by all processes of the plugin, so logs survive restarts
 }
 
 func (p *Launchpad) SetLaunchers(params map[string]types.CmdParam) error {
+	p.switching.Lock()
+	defer p.switching.Unlock()
+
+	var errs Errors
+	var retired []*Launcher
+	var sinks []*LogSink
+
 	p.mu.Lock()
-	for k, l := range p.launchers {
-		if param, ok := params[k]; !ok || changed(l.CmdParam, param) {
-			l.Close()
+	for k, slots := range p.launchers {
+		if _, ok := params[k]; !ok {
+			retired = append(retired, slots...)
 			delete(p.launchers, k)
+			metrics.RecordPluginUp(k, false)
+		}
+	}
+	for k := range p.states {
+		if _, ok := params[k]; !ok {
+			sinks = append(sinks, p.states[k].sink)
+			delete(p.states, k)
+		}
+	}
+	for k, param := range params {
+		state, ok := p.states[k]
+		if !ok {
+			state = &pluginState{}
+			p.states[k] = state
+		}
+		if state.sink != nil && !reflect.DeepEqual(state.param.Logs, param.Logs) {
+			sinks = append(sinks, state.sink)
+			state.sink = nil
+		}
+		state.param = param
+		if slots := p.launchers[k]; len(slots) > instances(param) {
+			retired = append(retired, slots[instances(param):]...)
+			p.launchers[k] = slots[:instances(param)]
 		}
 	}
 	p.mu.Unlock()
 
-	var errs Errors
 	for k, param := range params {
 		p.mu.Lock()
-		if _, ok := p.launchers[k]; !ok {
-			p.mu.Unlock()
+		current := append([]*Launcher(nil), p.launchers[k]...)
+		stopped := p.states[k].stopped
+		p.mu.Unlock()
 
-			launcher := NewLauncher(param, nil)
-			_, err := launcher.Launch()
+		if stopped {
+			continue
+		}
+		if err := validBalance(param); err != nil {
+			p.setErr(k, err)
+			errs.Append(err)
+			continue
+		}
+
+		for i := 0; i < instances(param); i++ {
+			if i < len(current) && current[i] != nil && !changed(current[i].CmdParam, param) {
+				continue
+			}
+
+			// start the new instance before switching to it, and keep the current one if the new one is not healthy.
+			launcher, err := p.launch(k, param)
 			if err != nil {
 				errs.Append(err)
-			} else {
-				p.mu.Lock()
-				p.launchers[k] = launcher
-				go func() {
-					if err := launcher.Err(); err != nil {
-						// TODO: logging
-					}
-					p.mu.Lock()
-					defer p.mu.Unlock()
-					launcher.Close()
-					delete(p.launchers, k)
-				}()
-				p.mu.Unlock()
+				continue
 			}
-		} else {
-			p.mu.Unlock()
+
+			retired = append(retired, p.switchTo(k, i, launcher))
+		}
+	}
+
+	p.retire(retired...)
+
+	for _, sink := range sinks {
+		if sink != nil {
+			sink.Close()
 		}
 	}
+
 	if errs.Len() > 0 {
 		return &errs
 	}
 	return nil
 }
 
+// Restart relaunches instances of the plugin one by one by its latest param, and an instance is kept if its replacement fails.
+// It also resumes a plugin stopped by Stop.
+func (p *Launchpad) Restart(name string) error {
+	p.switching.Lock()
+	defer p.switching.Unlock()
+
+	p.mu.Lock()
+	state, ok := p.states[name]
+	p.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("fail to restart plugin %q: %w", name, PluginNotFoundErr)
+	}
+
+	var errs Errors
+	for i := 0; i < instances(state.param); i++ {
+		launcher, err := p.launch(name, state.param)
+		if err != nil {
+			errs.Append(err)
+			continue
+		}
+
+		p.mu.Lock()
+		state.stopped = false
+		p.mu.Unlock()
+
+		p.retire(p.switchTo(name, i, launcher))
+	}
+
+	if errs.Len() > 0 {
+		return &errs
+	}
+	return nil
+}
+
+// Stop shuts down all instances of the plugin gracefully, and it will not be launched by SetLaunchers again until Restart.
+func (p *Launchpad) Stop(name string) error {
+	p.switching.Lock()
+	defer p.switching.Unlock()
+
+	p.mu.Lock()
+	state, ok := p.states[name]
+	if !ok {
+		p.mu.Unlock()
+		return fmt.Errorf("fail to stop plugin %q: %w", name, PluginNotFoundErr)
+	}
+	state.stopped = true
+	current := p.launchers[name]
+	delete(p.launchers, name)
+	metrics.RecordPluginUp(name, false)
+	p.mu.Unlock()
+
+	p.retire(current...)
+	return nil
+}
+
+func (p *Launchpad) launch(name string, param types.CmdParam) (launcher *Launcher, err error) {
+	defer func() {
+		if err != nil {
+			p.setErr(name, err)
+		}
+	}()
+
+	launcher = NewLauncher(param, nil)
+	if launcher.Sink, err = p.sink(name, param); err != nil {
+		return nil, err
+	}
+	if _, err := launcher.Launch(); err != nil {
+		launcher.Close()
+		return nil, err
+	}
+	if err := launcher.Ping(); err != nil {
+		launcher.Close()
+		return nil, fmt.Errorf("fail to check the health of plugin %s: %w", param.Name, err)
+	}
+	return launcher, nil
+}
+
+// sink returns the log sink of the plugin, which is created on the first launch and closed once the plugin is removed.
+func (p *Launchpad) sink(name string, param types.CmdParam) (*LogSink, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state, ok := p.states[name]
+	if !ok {
+		// the plugin is removed by SetLaunchers, so there is nowhere to keep the sink.
+		return nil, fmt.Errorf("fail to launch plugin %q: %w", name, PluginNotFoundErr)
+	}
+	if state.sink == nil {
+		sink, err := NewLogSink(name, param.Logs, nil)
+		if err != nil {
+			return nil, err
+		}
+		state.sink = sink
+	}
+	return state.sink, nil
+}
+
+// switchTo makes GetController serve the launcher in the slot, and returns the replaced one.
+func (p *Launchpad) switchTo(name string, slot int, launcher *Launcher) (old *Launcher) {
+	p.mu.Lock()
+	slots := p.launchers[name]
+	for len(slots) <= slot {
+		slots = append(slots, nil)
+	}
+	old, slots[slot] = slots[slot], launcher
+	p.launchers[name] = slots
+	if state, ok := p.states[name]; ok {
+		state.err = nil
+		state.rejected = ""
+		for len(state.launches) <= slot {
+			state.launches = append(state.launches, 0)
+		}
+		state.launches[slot]++
+		metrics.RecordPluginRestarts(name, int64(restarts(state.launches)))
+	}
+	metrics.RecordPluginUp(name, true)
+	p.mu.Unlock()
+	go p.watch(name, launcher)
+	return old
+}
+
+// watch removes the instance from its slot once it exits, without affecting other instances of the plugin.
+func (p *Launchpad) watch(name string, launcher *Launcher) {
+	err := launcher.Err()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	launcher.Close()
+	slots := p.launchers[name]
+	for i, l := range slots {
+		if l != launcher {
+			continue
+		}
+		slots[i] = nil
+		if running(slots) == 0 {
+			delete(p.launchers, name)
+			metrics.RecordPluginUp(name, false)
+		}
+		if state, ok := p.states[name]; ok && err != nil {
+			state.err = err
+		}
+	}
+}
+
+func (p *Launchpad) setErr(name string, err error) {
+	p.mu.Lock()
+	if state, ok := p.states[name]; ok {
+		state.err = err
+	}
+	p.mu.Unlock()
+}
+
+func (p *Launchpad) watchBinaries(ctx context.Context) {
+	ticker := time.NewTicker(p.watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.reloadChanged()
+		}
+	}
+}
+
+// reloadChanged relaunches instances whose binary is changed on disk, and an instance is kept if its replacement fails.
+func (p *Launchpad) reloadChanged() {
+	p.switching.Lock()
+	defer p.switching.Unlock()
+
+	p.mu.Lock()
+	current := make(map[string][]*Launcher, len(p.launchers))
+	for k, slots := range p.launchers {
+		current[k] = append([]*Launcher(nil), slots...)
+	}
+	p.mu.Unlock()
+
+	for name, slots := range current {
+		for i, l := range slots {
+			if l == nil {
+				continue
+			}
+
+			// the launcher is shared with Status and GetController, so its snapshot is written under mu
+			bin, modified, err := l.disk.modified()
+			p.mu.Lock()
+			l.disk = bin
+			p.mu.Unlock()
+			if err != nil || !modified {
+				continue
+			}
+
+			p.mu.Lock()
+			state, ok := p.states[name]
+			rejected := ok && state.rejected == bin.Checksum
+			p.mu.Unlock()
+			if !ok || rejected {
+				continue
+			}
+
+			launcher, err := p.launch(name, l.CmdParam)
+			if err != nil {
+				p.mu.Lock()
+				state.rejected = bin.Checksum
+				p.mu.Unlock()
+				p.record(name, types.Meta{
+					"type":     "plugin_reload_failed",
+					"plugin":   name,
+					"instance": i,
+					"old":      l.Checksum(),
+					"new":      bin.Checksum,
+					"error":    err.Error(),
+				})
+				continue
+			}
+
+			old := p.switchTo(name, i, launcher)
+
+			p.record(name, types.Meta{
+				"type":     "plugin_reloaded",
+				"plugin":   name,
+				"instance": i,
+				"old":      l.Checksum(),
+				"new":      launcher.Checksum(),
+			})
+
+			p.retire(old)
+		}
+	}
+}
+
+func (p *Launchpad) record(name string, meta types.Meta) {
+	if p.events == nil {
+		return
+	}
+	p.mu.Lock()
+	var pools []string
+	if state, ok := p.states[name]; ok {
+		pools = state.param.Pools
+	}
+	p.mu.Unlock()
+	for _, pool := range pools {
+		if err := p.events.AppendEvent(types.ResourceEvent{
+			ResourcePoolID: pool,
+			Timestamp:      time.Now(),
+			Meta:           types.Merge(meta, nil),
+		}); err != nil {
+			// TODO: logging
+		}
+	}
+}
+
+// retire drains and shuts down launchers that are no longer served by GetController.
+func (p *Launchpad) retire(launchers ...*Launcher) {
+	var wg sync.WaitGroup
+	for _, l := range launchers {
+		if l == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(l *Launcher) {
+			defer wg.Done()
+			l.Shutdown(p.drainTimeout)
+		}(l)
+	}
+	wg.Wait()
+}
+
+// GetController returns the controller of the plugin, which spreads calls across its running instances.
 func (p *Launchpad) GetController(name string) (controller types.Controller, err error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if l, ok := p.launchers[name]; ok {
-		return l.Controller, nil
+	slots := p.launchers[name]
+	if running(slots) == 0 {
+		return nil, fmt.Errorf("fail to get controller %q: %w", name, ControllerNotFoundErr)
+	}
+	if len(slots) == 1 {
+		return slots[0].Controller, nil
+	}
+	return &balancer{
+		instances: append([]*Launcher(nil), slots...),
+		balance:   p.states[name].param.Balance,
+	}, nil
+}
+
+// Status returns the status of each plugin set by SetLaunchers, sorted by name.
+// The PID, StartedAt, ProtocolVersion and Checksum are of the first running instance.
+func (p *Launchpad) Status() []types.PluginStatus {
+	p.mu.Lock()
+	ret := make([]types.PluginStatus, 0, len(p.states))
+	running := make([][]*Launcher, 0, len(p.states))
+	for name, state := range p.states {
+		status := types.PluginStatus{Name: name, Stopped: state.stopped}
+		slots := append([]*Launcher(nil), p.launchers[name]...)
+		for i := 0; i < instances(state.param) || i < len(slots); i++ {
+			instance := types.InstanceStatus{Slot: i}
+			if i < len(state.launches) && state.launches[i] > 1 {
+				instance.Restarts = state.launches[i] - 1
+				status.Restarts += instance.Restarts
+			}
+			if i < len(slots) && slots[i] != nil {
+				l := slots[i]
+				instance.Running = true
+				instance.PID = l.pid()
+				instance.StartedAt = l.startedAt
+				instance.Inflight = l.calls.load()
+				if !status.Running {
+					status.Running = true
+					status.PID = instance.PID
+					status.StartedAt = instance.StartedAt
+					status.ProtocolVersion = l.version
+					status.Checksum = l.Checksum()
+				}
+			}
+			status.Instances = append(status.Instances, instance)
+		}
+		if state.sink != nil {
+			status.Logs = state.sink.Lines()
+		}
+		if state.err != nil {
+			status.LastError = state.err.Error()
+			var lerr *LaunchError
+			if errors.As(state.err, &lerr) {
+				status.LastOutput = lerr.Output
+				status.ExitStatus = lerr.ExitStatus
+			}
+		}
+		ret = append(ret, status)
+		running = append(running, slots)
 	}
-	return nil, fmt.Errorf("fail to get controller %q: %w", name, ControllerNotFoundErr)
+	p.mu.Unlock()
+
+	// health checks are done without holding the lock, since they are rpc calls.
+	for i, slots := range running {
+		ret[i].Healthy = ret[i].Running
+		for j, l := range slots {
+			if l != nil {
+				ret[i].Instances[j].Healthy = l.Ping() == nil
+				ret[i].Healthy = ret[i].Healthy && ret[i].Instances[j].Healthy
+			}
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
 }
 
 func (p *Launchpad) Close() {
+	p.cancel()
 	p.SetLaunchers(map[string]types.CmdParam{})
 }
 
+// restarts sums the relaunches of all slots.
+func restarts(launches []int) (n int) {
+	for _, l := range launches {
+		if l > 1 {
+			n += l - 1
+		}
+	}
+	return
+}
+
+// changed reports whether the plugin should be relaunched, where Pools is only used for events and therefore ignored,
+// and Instances and Balance are applied without relaunching.
 func changed(p1, p2 types.CmdParam) bool {
-	if p1.Path != p2.Path {
+	if !sameSet(p1.Envs, p2.Envs) || !sameSet(p1.Owners, p2.Owners) || !sameSet(p1.EnvAllowlist, p2.EnvAllowlist) {
 		return true
 	}
 
-	if len(p1.Envs) != len(p2.Envs) {
-		return true
+	p1.Envs, p1.Owners, p1.EnvAllowlist, p1.Pools, p1.Instances, p1.Balance = nil, nil, nil, nil, 0, ""
+	p2.Envs, p2.Owners, p2.EnvAllowlist, p2.Pools, p2.Instances, p2.Balance = nil, nil, nil, nil, 0, ""
+	return !reflect.DeepEqual(p1, p2)
+}
+
+func sameSet(s1, s2 []string) bool {
+	if len(s1) != len(s2) {
+		return false
 	}
 
-	for _, v1 := range p1.Envs {
+	for _, v1 := range s1 {
 		found := false
-		for _, v2 := range p2.Envs {
+		for _, v2 := range s2 {
 			if v1 == v2 {
 				found = true
 			}
 		}
 		if !found {
-			return true
+			return false
 		}
 	}
-	return false
+	return true
 }
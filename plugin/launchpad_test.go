@@ -2,11 +2,18 @@ package plugin
 
 import (
 	"errors"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/metrics"
+	"github.com/rueian/godemand/resource"
 	"github.com/rueian/godemand/types"
+	"go.opencensus.io/stats/view"
 )
 
 var _ = Describe("LaunchPad", func() {
@@ -15,7 +22,7 @@ var _ = Describe("LaunchPad", func() {
 	var err error
 
 	BeforeEach(func() {
-		launchpad = NewLaunchpad()
+		launchpad = NewLaunchpad(WithDrainTimeout(time.Second))
 		params = map[string]types.CmdParam{
 			"puppet": {
 				Name: "PuppetController",
@@ -55,6 +62,11 @@ var _ = Describe("LaunchPad", func() {
 			})
 		})
 
+		It("not launch plugins removed from the launchpad", func() {
+			_, err := launchpad.launch("removed", params["puppet"])
+			Expect(errors.Is(err, PluginNotFoundErr)).To(BeTrue())
+		})
+
 		Context("with same input", func() {
 			JustBeforeEach(func() {
 				err = launchpad.SetLaunchers(params)
@@ -62,12 +74,15 @@ var _ = Describe("LaunchPad", func() {
 
 			It("no error", func() {
 				Expect(err).NotTo(HaveOccurred())
-				Expect(launchpad.launchers["puppet"].CmdParam).To(Equal(params["puppet"]))
+				Expect(launchpad.launchers["puppet"][0].CmdParam).To(Equal(params["puppet"]))
 			})
 		})
 
 		Context("with update", func() {
+			var old *Launcher
+
 			JustBeforeEach(func() {
+				old = launchpad.launchers["puppet"][0]
 				params["puppet"] = types.CmdParam{
 					Name: "PuppetController",
 					Path: "./mock/server/puppet",
@@ -78,19 +93,29 @@ var _ = Describe("LaunchPad", func() {
 
 			It("no error", func() {
 				Expect(err).NotTo(HaveOccurred())
-				Expect(launchpad.launchers["puppet"].CmdParam.Envs).To(ConsistOf("CUSTOM=2"))
+				Expect(launchpad.launchers["puppet"][0].CmdParam.Envs).To(ConsistOf("CUSTOM=2"))
+			})
+
+			It("switch to the new one and shutdown the old one", func() {
+				Expect(launchpad.launchers["puppet"][0]).NotTo(Equal(old))
+				Expect(launchpad.launchers["puppet"][0].Ping()).NotTo(HaveOccurred())
+				Expect(old.exited).To(BeClosed())
 			})
 		})
 
 		Context("with update failed", func() {
+			var old *Launcher
+
 			JustBeforeEach(func() {
+				old = launchpad.launchers["puppet"][0]
 				params["puppet"] = types.CmdParam{Path: "notfound"}
 				err = launchpad.SetLaunchers(params)
 			})
 
-			It("error", func() {
+			It("error and keep the old one", func() {
 				Expect(err.Error()).To(ContainSubstring(exec.ErrNotFound.Error()))
-				Expect(launchpad.launchers).NotTo(HaveKey("puppet"))
+				Expect(launchpad.launchers["puppet"][0]).To(Equal(old))
+				Expect(old.Ping()).NotTo(HaveOccurred())
 			})
 		})
 
@@ -107,6 +132,242 @@ var _ = Describe("LaunchPad", func() {
 		})
 	})
 
+	Describe("with instances", func() {
+		BeforeEach(func() {
+			param := params["puppet"]
+			param.Instances = 3
+			param.Balance = types.BalancePool
+			params["puppet"] = param
+		})
+
+		It("launch one process for each instance", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(launchpad.launchers["puppet"]).To(HaveLen(3))
+			status := launchpad.Status()[0]
+			Expect(status.Healthy).To(BeTrue())
+			Expect(status.Instances).To(HaveLen(3))
+			pids := map[int]bool{}
+			for _, instance := range status.Instances {
+				Expect(instance.Running).To(BeTrue())
+				Expect(instance.Healthy).To(BeTrue())
+				pids[instance.PID] = true
+			}
+			Expect(pids).To(HaveLen(3))
+
+			controller, err := launchpad.GetController("puppet")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(controller).To(BeAssignableToTypeOf(&balancer{}))
+		})
+
+		It("replace a crashed instance independently", func() {
+			crashed := launchpad.launchers["puppet"][1]
+			others := []*Launcher{launchpad.launchers["puppet"][0], launchpad.launchers["puppet"][2]}
+			crashed.command.Process.Kill()
+			Eventually(func() *Launcher {
+				launchpad.mu.Lock()
+				defer launchpad.mu.Unlock()
+				return launchpad.launchers["puppet"][1]
+			}).Should(BeNil())
+
+			_, err := launchpad.GetController("puppet")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(launchpad.SetLaunchers(params)).NotTo(HaveOccurred())
+			Expect(launchpad.launchers["puppet"][1]).NotTo(BeNil())
+			Expect(launchpad.launchers["puppet"][0]).To(Equal(others[0]))
+			Expect(launchpad.launchers["puppet"][2]).To(Equal(others[1]))
+			Expect(launchpad.Status()[0].Instances[1].Restarts).To(Equal(1))
+		})
+
+		It("shrink without relaunching the rest", func() {
+			first := launchpad.launchers["puppet"][0]
+			removed := launchpad.launchers["puppet"][1:]
+			param := params["puppet"]
+			param.Instances = 1
+			params["puppet"] = param
+
+			Expect(launchpad.SetLaunchers(params)).NotTo(HaveOccurred())
+			Expect(launchpad.launchers["puppet"]).To(Equal([]*Launcher{first}))
+			for _, l := range removed {
+				Expect(l.exited).To(BeClosed())
+			}
+		})
+
+		Context("with unknown balance", func() {
+			BeforeEach(func() {
+				param := params["puppet"]
+				param.Balance = "random"
+				params["puppet"] = param
+			})
+			It("return error", func() {
+				Expect(err.Error()).To(ContainSubstring(UnknownBalanceErr.Error()))
+				Expect(launchpad.launchers).NotTo(HaveKey("puppet"))
+			})
+		})
+	})
+
+	Describe("reloadChanged", func() {
+		var dir string
+		var dao *resource.InMemoryResourcePool
+		var old *Launcher
+
+		replace := func(content []byte) {
+			tmp := filepath.Join(dir, "tmp")
+			Expect(ioutil.WriteFile(tmp, content, 0755)).NotTo(HaveOccurred())
+			Expect(os.Rename(tmp, filepath.Join(dir, "puppet"))).NotTo(HaveOccurred())
+		}
+
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "launchpad")
+			content, _ := ioutil.ReadFile("./mock/server/puppet")
+			replace(content)
+
+			dao = resource.NewInMemoryResourcePool()
+			launchpad.events = dao
+			params["puppet"] = types.CmdParam{
+				Name:  "PuppetController",
+				Path:  filepath.Join(dir, "puppet"),
+				Pools: []string{"pool1"},
+			}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		JustBeforeEach(func() {
+			Expect(err).NotTo(HaveOccurred())
+			old = launchpad.launchers["puppet"][0]
+		})
+
+		Context("binary not changed", func() {
+			It("keep the current one", func() {
+				launchpad.reloadChanged()
+				Expect(launchpad.launchers["puppet"][0]).To(Equal(old))
+			})
+		})
+
+		Context("binary changed", func() {
+			JustBeforeEach(func() {
+				content, _ := ioutil.ReadFile("./mock/server/puppet")
+				replace(append(content, []byte("changed")...))
+				launchpad.reloadChanged()
+			})
+
+			It("switch to the new one and record event", func() {
+				Expect(launchpad.launchers["puppet"][0]).NotTo(Equal(old))
+				Expect(launchpad.launchers["puppet"][0].Ping()).NotTo(HaveOccurred())
+				Expect(launchpad.launchers["puppet"][0].Checksum()).NotTo(Equal(old.Checksum()))
+				Expect(old.exited).To(BeClosed())
+
+				events, _ := dao.GetEventsByPool("pool1", 1, time.Now())
+				Expect(events).To(HaveLen(1))
+				Expect(events[0].Meta).To(HaveKeyWithValue("type", "plugin_reloaded"))
+				Expect(events[0].Meta).To(HaveKeyWithValue("old", old.Checksum()))
+				Expect(events[0].Meta).To(HaveKeyWithValue("new", launchpad.launchers["puppet"][0].Checksum()))
+			})
+		})
+
+		Context("binary broken", func() {
+			JustBeforeEach(func() {
+				replace([]byte("broken"))
+				launchpad.reloadChanged()
+			})
+
+			It("keep the current one and record event", func() {
+				Expect(launchpad.launchers["puppet"][0]).To(Equal(old))
+				Expect(old.Ping()).NotTo(HaveOccurred())
+
+				events, _ := dao.GetEventsByPool("pool1", 1, time.Now())
+				Expect(events).To(HaveLen(1))
+				Expect(events[0].Meta).To(HaveKeyWithValue("type", "plugin_reload_failed"))
+			})
+		})
+	})
+
+	Describe("Status", func() {
+		BeforeEach(func() {
+			params["pinned"] = types.CmdParam{Path: "./mock/server/puppet", SHA256: "mismatched"}
+			params["crashed"] = types.CmdParam{Path: "/bin/sh", Args: []string{"-c", "echo crashed >&2; exit 3"}}
+		})
+
+		It("report running plugins, verification and launch failures", func() {
+			status := launchpad.Status()
+			Expect(status).To(HaveLen(3))
+			Expect(status[0].Name).To(Equal("crashed"))
+			Expect(status[0].Running).To(BeFalse())
+			Expect(status[0].LastOutput).To(Equal([]string{"stderr: crashed"}))
+			Expect(status[0].ExitStatus).To(Equal("exit status 3"))
+			Expect(status[1].Name).To(Equal("pinned"))
+			Expect(status[1].Running).To(BeFalse())
+			Expect(status[1].LastError).To(ContainSubstring(ChecksumMismatchErr.Error()))
+			Expect(status[2].Name).To(Equal("puppet"))
+			Expect(status[2].Running).To(BeTrue())
+			Expect(status[2].Healthy).To(BeTrue())
+			Expect(status[2].PID).NotTo(BeZero())
+			Expect(status[2].StartedAt).NotTo(BeZero())
+			Expect(status[2].ProtocolVersion).To(Equal(CurrentProtocolVersion))
+			Expect(status[2].Restarts).To(BeZero())
+			Expect(status[2].Checksum).NotTo(BeEmpty())
+			Expect(status[2].Logs).NotTo(BeEmpty())
+		})
+	})
+
+	Describe("Restart", func() {
+		It("relaunch the plugin", func() {
+			old := launchpad.launchers["puppet"][0]
+			Expect(launchpad.Restart("puppet")).NotTo(HaveOccurred())
+			Expect(launchpad.launchers["puppet"][0]).NotTo(Equal(old))
+			Expect(old.exited).To(BeClosed())
+			Expect(launchpad.Status()[0].Restarts).To(Equal(1))
+		})
+
+		It("get PluginNotFoundErr if not set", func() {
+			Expect(errors.Is(launchpad.Restart("random"), PluginNotFoundErr)).To(BeTrue())
+		})
+	})
+
+	Describe("Stop", func() {
+		It("stop the plugin until restart", func() {
+			old := launchpad.launchers["puppet"][0]
+			Expect(launchpad.Stop("puppet")).NotTo(HaveOccurred())
+			Expect(old.exited).To(BeClosed())
+			Expect(launchpad.launchers).NotTo(HaveKey("puppet"))
+
+			Expect(launchpad.SetLaunchers(params)).NotTo(HaveOccurred())
+			Expect(launchpad.launchers).NotTo(HaveKey("puppet"))
+			Expect(launchpad.Status()[0].Stopped).To(BeTrue())
+
+			Expect(launchpad.Restart("puppet")).NotTo(HaveOccurred())
+			Expect(launchpad.launchers).To(HaveKey("puppet"))
+			Expect(launchpad.Status()[0].Stopped).To(BeFalse())
+		})
+
+		It("get PluginNotFoundErr if not set", func() {
+			Expect(errors.Is(launchpad.Stop("random"), PluginNotFoundErr)).To(BeTrue())
+		})
+	})
+
+	Describe("metrics", func() {
+		BeforeEach(func() {
+			Expect(view.Register(metrics.PluginUpView, metrics.PluginRestartView)).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			view.Unregister(metrics.PluginUpView, metrics.PluginRestartView)
+		})
+
+		It("record restarts and up status of plugins", func() {
+			Expect(lastValue(metrics.PluginUpView, "puppet")).To(Equal(1.0))
+
+			Expect(launchpad.Restart("puppet")).NotTo(HaveOccurred())
+			Expect(lastValue(metrics.PluginRestartView, "puppet")).To(Equal(1.0))
+
+			Expect(launchpad.Stop("puppet")).NotTo(HaveOccurred())
+			Expect(lastValue(metrics.PluginUpView, "puppet")).To(Equal(0.0))
+		})
+	})
+
 	Describe("GetController", func() {
 		It("get launched controller", func() {
 			controller, _ := launchpad.GetController("puppet")
@@ -125,3 +386,17 @@ var _ = Describe("LaunchPad", func() {
 		})
 	})
 })
+
+// lastValue returns the last value recorded by the view for the plugin, or -1 if there is none.
+func lastValue(v *view.View, plugin string) float64 {
+	rows, err := view.RetrieveData(v.Name)
+	Expect(err).NotTo(HaveOccurred())
+	for _, row := range rows {
+		for _, t := range row.Tags {
+			if t.Key == metrics.KeyPlugin && t.Value == plugin {
+				return row.Data.(*view.LastValueData).Value
+			}
+		}
+	}
+	return -1
+}
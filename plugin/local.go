@@ -0,0 +1,155 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"time"
+
+	"github.com/rueian/godemand/metrics"
+	"github.com/rueian/godemand/types"
+)
+
+var UnknownKindErr = errors.New("unknown plugin kind")
+
+// localController returns the controller served in process for builtin and non process kinds of plugins.
+func (l *Launcher) localController() (types.Controller, bool, error) {
+	param := l.CmdParam
+	if name, ok := builtinName(param.Path); ok {
+		controller, err := lookupBuiltin(param, name)
+		return controller, true, err
+	}
+	switch param.Kind {
+	case "", types.KindProcess:
+		return nil, false, nil
+	case types.KindWebhook:
+		controller, err := NewWebhookController(param.Webhook)
+		return controller, true, err
+	case types.KindExec:
+		controller, err := NewExecController(param, l.Sink)
+		return controller, true, err
+	case types.KindWasm:
+		code, err := l.readFile()
+		if err != nil {
+			return nil, true, err
+		}
+		controller, err := newWasmController(param, l.Sink, code)
+		return controller, true, err
+	case types.KindStarlark:
+		if param.Starlark.Source != "" {
+			controller, err := NewStarlarkController(param, l.Sink)
+			return controller, true, err
+		}
+		src, err := l.readFile()
+		if err != nil {
+			return nil, true, err
+		}
+		controller, err := newStarlarkController(param, l.Sink, string(src), param.Path)
+		return controller, true, err
+	}
+	return nil, true, fmt.Errorf("fail to launch plugin %s of kind %q: %w", param.Name, param.Kind, UnknownKindErr)
+}
+
+// readFile reads and verifies the file loaded by the controller, which is then watched for changes like a process binary.
+// The verified content is loaded instead of reading the path again.
+func (l *Launcher) readFile() ([]byte, error) {
+	bin, content, err := readFile(l.CmdParam.Path)
+	if err != nil {
+		return nil, err
+	}
+	if err := bin.verify(l.CmdParam); err != nil {
+		return nil, err
+	}
+	l.binary, l.disk = bin, bin
+	return content, nil
+}
+
+func (l *Launcher) launchLocal(ctx context.Context, controller types.Controller) (types.Controller, error) {
+	l.startedAt = time.Now()
+	l.version = CurrentProtocolVersion
+	l.doneCh = make(chan error)
+	l.exited = make(chan struct{})
+	// the builtin controllers are shared by every launch of them, so only those built by this launcher are closed.
+	_, builtin := builtinName(l.CmdParam.Path)
+	go func() {
+		<-ctx.Done()
+		if closer, ok := controller.(io.Closer); ok && !builtin {
+			closer.Close()
+		}
+		close(l.doneCh)
+		close(l.exited)
+	}()
+
+	l.local = &localClient{tracker: &tracker{}, controller: controller, done: ctx.Done()}
+	l.calls = l.local.tracker
+	l.Controller = &observed{name: l.CmdParam.Name, next: l.local, outcome: localOutcome}
+	return l.Controller, nil
+}
+
+// localClient calls the in process controller directly, and refuses calls once its launcher is closed like a rpcClient does.
+type localClient struct {
+	*tracker
+	controller types.Controller
+	done       <-chan struct{}
+}
+
+func (c *localClient) FindResource(pool types.ResourcePool, params map[string]interface{}) (types.Resource, error) {
+	return c.FindResourceContext(context.Background(), pool, params)
+}
+
+func (c *localClient) SyncResource(resource types.Resource, params map[string]interface{}) (types.Resource, error) {
+	return c.SyncResourceContext(context.Background(), resource, params)
+}
+
+func (c *localClient) FindResourceContext(ctx context.Context, pool types.ResourcePool, params map[string]interface{}) (types.Resource, error) {
+	if err := c.alive(); err != nil {
+		return types.Resource{}, err
+	}
+	c.begin()
+	defer c.end()
+	return types.FindResource(ctx, c.controller, pool, params)
+}
+
+func (c *localClient) SyncResourceContext(ctx context.Context, resource types.Resource, params map[string]interface{}) (types.Resource, error) {
+	if err := c.alive(); err != nil {
+		return types.Resource{}, err
+	}
+	c.begin()
+	defer c.end()
+	return types.SyncResource(ctx, c.controller, resource, params)
+}
+
+func (c *localClient) ListExternal(pool types.ResourcePool, params map[string]interface{}) ([]types.Resource, error) {
+	lister, ok := c.controller.(types.ExternalLister)
+	if !ok {
+		return nil, fmt.Errorf("fail to list external resources: %w", types.ExternalListNotSupportedErr)
+	}
+	if err := c.alive(); err != nil {
+		return nil, err
+	}
+	c.begin()
+	defer c.end()
+	return lister.ListExternal(pool, params)
+}
+
+func (c *localClient) alive() error {
+	select {
+	case <-c.done:
+		return fmt.Errorf("fail to call the closed controller: %w", rpc.ErrShutdown)
+	default:
+		return nil
+	}
+}
+
+// localOutcome takes every error as returned by the controller, except calls refused by the closed launcher.
+func localOutcome(err error) string {
+	switch {
+	case err == nil:
+		return metrics.OutcomeOK
+	case errors.Is(err, rpc.ErrShutdown):
+		return metrics.OutcomeUnavailable
+	}
+	return metrics.OutcomeError
+}
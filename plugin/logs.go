@@ -0,0 +1,198 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rueian/godemand/types"
+)
+
+const DefaultLogBuffer = 100
+
+// LogSink receives output lines of a plugin, keeps the recent ones in memory,
+// and writes them to either a rotating file or the host logger.
+// It is shared by all processes launched for the same plugin.
+type LogSink struct {
+	name   string
+	json   bool
+	ring   *logRing
+	file   *rotatingFile
+	logger *log.Logger
+}
+
+func NewLogSink(name string, cfg types.LogConfig, logger *log.Logger) (*LogSink, error) {
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	if cfg.Buffer <= 0 {
+		cfg.Buffer = DefaultLogBuffer
+	}
+	sink := &LogSink{name: name, json: cfg.JSON, ring: newLogRing(cfg.Buffer), logger: logger}
+	if cfg.File != "" {
+		f, err := openRotatingFile(cfg.File, cfg.MaxSize, cfg.MaxFiles)
+		if err != nil {
+			return nil, err
+		}
+		sink.file = f
+	}
+	return sink, nil
+}
+
+func (s *LogSink) Write(pid int, stream, text string) {
+	line := types.LogLine{Time: time.Now(), PID: pid, Stream: stream, Text: text}
+	if s.json && strings.HasPrefix(text, "{") {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(text), &fields); err == nil {
+			line.Fields = fields
+		}
+	}
+	s.ring.Add(line)
+
+	if s.file != nil {
+		if err := s.file.WriteLine(fmt.Sprintf("%s %d %s: %s", line.Time.Format(time.RFC3339Nano), pid, stream, text)); err == nil {
+			return
+		}
+	}
+	s.logger.Println(s.name + " " + stream + ": " + text)
+}
+
+// Lines returns the buffered lines from the oldest to the newest.
+func (s *LogSink) Lines() []types.LogLine {
+	return s.ring.Lines()
+}
+
+// Tail returns the last n lines printed by the process.
+func (s *LogSink) Tail(pid, n int) (lines []string) {
+	all := s.ring.Lines()
+	for i := len(all) - 1; i >= 0 && len(lines) < n; i-- {
+		if all[i].PID == pid {
+			lines = append(lines, all[i].Stream+": "+all[i].Text)
+		}
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines
+}
+
+func (s *LogSink) Close() error {
+	if s.file != nil {
+		return s.file.Close()
+	}
+	return nil
+}
+
+// logRing keeps the last n lines written to it.
+type logRing struct {
+	mu    sync.Mutex
+	lines []types.LogLine
+	next  int
+	full  bool
+}
+
+func newLogRing(n int) *logRing {
+	return &logRing{lines: make([]types.LogLine, n)}
+}
+
+func (r *logRing) Add(line types.LogLine) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+func (r *logRing) Lines() []types.LogLine {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]types.LogLine(nil), r.lines[:r.next]...)
+	}
+	return append(append([]types.LogLine(nil), r.lines[r.next:]...), r.lines[:r.next]...)
+}
+
+// rotatingFile rotates path to path.1, path.1 to path.2 and so on once its size exceeds maxSize,
+// and keeps at most maxFiles rotated files. A zero maxSize disables the rotation.
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *rotatingFile) WriteLine(line string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(line))+1 > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := f.file.WriteString(line + "\n")
+	f.size += int64(n)
+	return err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	if f.maxFiles > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxFiles))
+		for i := f.maxFiles - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Truncate(f.path, 0); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
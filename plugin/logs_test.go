@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/types"
+)
+
+var _ = Describe("LogSink", func() {
+	var sink *LogSink
+	var cfg types.LogConfig
+	var buf *bytes.Buffer
+	var dir string
+	var err error
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "logs")
+		cfg = types.LogConfig{Buffer: 3}
+		buf = &bytes.Buffer{}
+	})
+
+	JustBeforeEach(func() {
+		sink, err = NewLogSink("plugin1", cfg, log.New(buf, "", 0))
+	})
+
+	AfterEach(func() {
+		if sink != nil {
+			sink.Close()
+		}
+		os.RemoveAll(dir)
+	})
+
+	It("keep the last lines and forward them to the logger", func() {
+		Expect(err).NotTo(HaveOccurred())
+		for _, text := range []string{"1", "2", "3", "4"} {
+			sink.Write(10, "stdout", text)
+		}
+		sink.Write(11, "stderr", "5")
+
+		lines := sink.Lines()
+		Expect(lines).To(HaveLen(3))
+		Expect(lines[0].Text).To(Equal("3"))
+		Expect(lines[2].Stream).To(Equal("stderr"))
+		Expect(sink.Tail(10, 5)).To(Equal([]string{"stdout: 3", "stdout: 4"}))
+		Expect(buf.String()).To(ContainSubstring("plugin1 stderr: 5"))
+	})
+
+	Context("with json", func() {
+		BeforeEach(func() {
+			cfg.JSON = true
+		})
+		It("parse json lines into fields", func() {
+			sink.Write(10, "stdout", `{"level":"info","n":1}`)
+			sink.Write(10, "stdout", `{not json`)
+
+			lines := sink.Lines()
+			Expect(lines[0].Fields).To(Equal(map[string]interface{}{"level": "info", "n": float64(1)}))
+			Expect(lines[1].Fields).To(BeNil())
+		})
+	})
+
+	Context("with rotating file", func() {
+		BeforeEach(func() {
+			cfg.File = filepath.Join(dir, "plugin.log")
+			cfg.MaxSize = 100
+			cfg.MaxFiles = 2
+		})
+		It("rotate the file by size", func() {
+			Expect(err).NotTo(HaveOccurred())
+			for i := 0; i < 10; i++ {
+				sink.Write(10, "stdout", strings.Repeat("x", 30))
+			}
+			Expect(buf.Len()).To(BeZero())
+
+			files, _ := filepath.Glob(cfg.File + "*")
+			Expect(files).To(ConsistOf(cfg.File, cfg.File+".1", cfg.File+".2"))
+			for _, f := range files {
+				info, _ := os.Stat(f)
+				Expect(info.Size()).To(BeNumerically("<=", 100))
+			}
+		})
+	})
+
+	Context("with file not writable", func() {
+		BeforeEach(func() {
+			cfg.File = filepath.Join(dir, "missing", "plugin.log")
+		})
+		It("fail", func() {
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -18,8 +18,10 @@ import (
 //go:generate go build -o puppet .
 
 func main() {
-	// print all envs for testing
+	// print all envs, args and working dir for testing
 	fmt.Println(strings.Join(os.Environ(), " "))
+	wd, _ := os.Getwd()
+	fmt.Println("args:", strings.Join(os.Args[1:], " "), "dir:", wd)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
@@ -67,3 +69,18 @@ func (*PuppetController) SyncResource(resource types.Resource, params map[string
 	}
 	return resource, err
 }
+
+func (*PuppetController) ListExternal(pool types.ResourcePool, params map[string]interface{}) (res []types.Resource, err error) {
+	if errMsg, ok := params["err"]; ok {
+		return res, errors.New(errMsg.(string))
+	}
+	for _, r := range pool.Resources {
+		res = append(res, types.Resource{ID: r.ID, PoolID: r.PoolID})
+	}
+	if ids, ok := params["external"]; ok {
+		for _, id := range ids.([]interface{}) {
+			res = append(res, types.Resource{ID: id.(string), PoolID: pool.ID})
+		}
+	}
+	return res, nil
+}
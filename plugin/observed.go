@@ -0,0 +1,62 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rueian/godemand/metrics"
+	"github.com/rueian/godemand/tracing"
+	"github.com/rueian/godemand/types"
+	"go.opencensus.io/trace"
+)
+
+// observed traces and records the metrics of the calls to a launched plugin, whether it is served by a process or in process.
+// The outcome tells the errors returned by the controller from the failures of calling it.
+type observed struct {
+	name    string
+	next    types.Controller
+	outcome func(err error) string
+}
+
+func (o *observed) FindResource(pool types.ResourcePool, params map[string]interface{}) (types.Resource, error) {
+	return o.FindResourceContext(context.Background(), pool, params)
+}
+
+func (o *observed) SyncResource(resource types.Resource, params map[string]interface{}) (types.Resource, error) {
+	return o.SyncResourceContext(context.Background(), resource, params)
+}
+
+func (o *observed) FindResourceContext(ctx context.Context, pool types.ResourcePool, params map[string]interface{}) (res types.Resource, err error) {
+	ctx, _, done := o.start(ctx, "FindResource", pool.ID)
+	defer func() { done(err) }()
+	return types.FindResource(ctx, o.next, pool, params)
+}
+
+func (o *observed) SyncResourceContext(ctx context.Context, resource types.Resource, params map[string]interface{}) (res types.Resource, err error) {
+	ctx, span, done := o.start(ctx, "SyncResource", resource.PoolID)
+	defer func() { done(err) }()
+	span.AddAttributes(trace.StringAttribute(tracing.AttrResource, resource.ID))
+	return types.SyncResource(ctx, o.next, resource, params)
+}
+
+func (o *observed) ListExternal(pool types.ResourcePool, params map[string]interface{}) (res []types.Resource, err error) {
+	lister, ok := o.next.(types.ExternalLister)
+	if !ok {
+		return nil, fmt.Errorf("fail to list external resources: %w", types.ExternalListNotSupportedErr)
+	}
+	_, _, done := o.start(context.Background(), "ListExternal", pool.ID)
+	defer func() { done(err) }()
+	return lister.ListExternal(pool, params)
+}
+
+// start starts the client span of the method, and returns the func to end the span and record the call.
+func (o *observed) start(ctx context.Context, method, pool string) (context.Context, *trace.Span, func(err error)) {
+	ctx, span := trace.StartSpan(ctx, tracing.SpanPlugin+method, trace.WithSpanKind(trace.SpanKindClient))
+	span.AddAttributes(trace.StringAttribute(tracing.AttrPlugin, o.name), trace.StringAttribute(tracing.AttrPool, pool))
+	start := time.Now()
+	return ctx, span, func(err error) {
+		tracing.End(span, err)
+		metrics.RecordPluginCall(o.name, pool, method, o.outcome(err), time.Since(start))
+	}
+}
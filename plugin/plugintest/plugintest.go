@@ -0,0 +1,254 @@
+// Package plugintest launches plugins through the real plugin.Launcher, and drives scenarios of controller calls against them.
+package plugintest
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rueian/godemand/plugin"
+	"github.com/rueian/godemand/types"
+)
+
+const DefaultShutdownTimeout = 5 * time.Second
+
+var ResourceNotInPoolErr = errors.New("resource not in the harness pool")
+
+// Build compiles the go package of a plugin into a temporary directory and returns the path of the binary,
+// which is removed by the cleanup.
+func Build(pkg string) (path string, cleanup func(), err error) {
+	dir, err := ioutil.TempDir("", "plugintest")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+
+	path = filepath.Join(dir, "plugin")
+	if out, err := exec.Command("go", "build", "-o", path, pkg).CombinedOutput(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("fail to build plugin %s: %w\n%s", pkg, err, out)
+	}
+	return path, cleanup, nil
+}
+
+// Harness holds a launched plugin and an in-memory pool, which is updated by the results of the calls
+// the same way as the api and the syncer do.
+type Harness struct {
+	Launcher   *plugin.Launcher
+	Controller types.Controller
+	Pool       types.ResourcePool
+}
+
+// Launch launches the plugin by the param, and the harness pool is named by the first pool of the param or "test".
+func Launch(param types.CmdParam) (*Harness, error) {
+	if param.Name == "" {
+		param.Name = filepath.Base(param.Path)
+	}
+	poolID := "test"
+	if len(param.Pools) > 0 {
+		poolID = param.Pools[0]
+	}
+
+	launcher := plugin.NewLauncher(param, nil)
+	controller, err := launcher.Launch()
+	if err != nil {
+		launcher.Close()
+		return nil, err
+	}
+	return &Harness{
+		Launcher:   launcher,
+		Controller: controller,
+		Pool:       types.ResourcePool{ID: poolID, Resources: make(map[string]types.Resource)},
+	}, nil
+}
+
+// Close shuts the plugin down gracefully.
+func (h *Harness) Close() {
+	h.Launcher.Shutdown(DefaultShutdownTimeout)
+}
+
+// Logs returns the captured output lines of the plugin.
+func (h *Harness) Logs() []types.LogLine {
+	return h.Launcher.Sink.Lines()
+}
+
+// Find calls FindResource with the harness pool, and saves the valid result into the pool.
+func (h *Harness) Find(params map[string]interface{}) (types.Resource, error) {
+	res, err := h.Controller.FindResource(h.Pool, params)
+	if err != nil {
+		return res, err
+	}
+	if err := types.ValidateFound(h.Pool, res); err != nil {
+		return res, err
+	}
+	res.PoolID = h.Pool.ID
+	if prev, ok := h.Pool.Resources[res.ID]; !ok {
+		res.CreatedAt = time.Now()
+		res.StateChange = time.Now()
+	} else if prev.State != res.State && prev.StateChange == res.StateChange {
+		res.StateChange = time.Now()
+	}
+	h.Pool.Resources[res.ID] = res
+	return res, nil
+}
+
+// Sync calls SyncResource with the resource in the harness pool, and saves the valid result into the pool,
+// or removes it from the pool once it is deleted.
+func (h *Harness) Sync(id string, params map[string]interface{}) (types.Resource, error) {
+	prev, ok := h.Pool.Resources[id]
+	if !ok {
+		return types.Resource{}, fmt.Errorf("fail to sync %q: %w", id, ResourceNotInPoolErr)
+	}
+	res, err := h.Controller.SyncResource(prev, params)
+	if err != nil {
+		return res, err
+	}
+	if err := types.ValidateSynced(prev, res); err != nil {
+		return res, err
+	}
+	if res.State != prev.State && res.StateChange == prev.StateChange {
+		res.StateChange = time.Now()
+	}
+	if res.State == types.ResourceDeleted {
+		delete(h.Pool.Resources, id)
+	} else {
+		h.Pool.Resources[id] = res
+	}
+	return res, nil
+}
+
+// Step is a call of a scenario, which syncs the resource of the ResourceID, or finds one if the ResourceID is empty.
+// The result of the call is checked by the expectations in order.
+type Step struct {
+	Name       string
+	ResourceID string
+	Params     map[string]interface{}
+	Expect     []Expectation
+}
+
+// Expectation checks the result of a step.
+type Expectation func(res types.Resource, err error) error
+
+// Run runs the steps in order, and stops at the first failed expectation.
+func (h *Harness) Run(steps ...Step) error {
+	for i, step := range steps {
+		var res types.Resource
+		var err error
+		if step.ResourceID == "" {
+			res, err = h.Find(step.Params)
+		} else {
+			res, err = h.Sync(step.ResourceID, step.Params)
+		}
+		for _, expect := range step.Expect {
+			if failure := expect(res, err); failure != nil {
+				name := step.Name
+				if name == "" {
+					name = fmt.Sprintf("#%d", i)
+				}
+				return fmt.Errorf("step %s: %w", name, failure)
+			}
+		}
+	}
+	return nil
+}
+
+// T is the subset of testing.TB used by Test, which is also satisfied by ginkgo.GinkgoT().
+type T interface {
+	Fatal(args ...interface{})
+	Fatalf(format string, args ...interface{})
+}
+
+// Test launches the plugin, runs the steps and shuts the plugin down, where any failure fails the test.
+func Test(t T, param types.CmdParam, steps ...Step) {
+	if helper, ok := t.(interface{ Helper() }); ok {
+		helper.Helper()
+	}
+	h, err := Launch(param)
+	if err != nil {
+		t.Fatalf("fail to launch plugin %s: %v", param.Path, err)
+	}
+	defer h.Close()
+	if err := h.Run(steps...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// NoError asserts the call succeeds.
+func NoError() Expectation {
+	return func(res types.Resource, err error) error {
+		if err != nil {
+			return fmt.Errorf("unexpected error: %w", err)
+		}
+		return nil
+	}
+}
+
+// ErrorContains asserts the call fails with the message containing the substr.
+func ErrorContains(substr string) Expectation {
+	return func(res types.Resource, err error) error {
+		if err == nil {
+			return fmt.Errorf("expected error containing %q, got nil", substr)
+		}
+		if !strings.Contains(err.Error(), substr) {
+			return fmt.Errorf("expected error containing %q, got %q", substr, err)
+		}
+		return nil
+	}
+}
+
+// ErrorIs asserts the call fails with the target in its chain.
+func ErrorIs(target error) Expectation {
+	return func(res types.Resource, err error) error {
+		if !errors.Is(err, target) {
+			return fmt.Errorf("expected error %v, got %v", target, err)
+		}
+		return nil
+	}
+}
+
+// ID asserts the id of the resource.
+func ID(id string) Expectation {
+	return func(res types.Resource, err error) error {
+		if res.ID != id {
+			return fmt.Errorf("expected resource %q, got %q", id, res.ID)
+		}
+		return nil
+	}
+}
+
+// State asserts the state of the resource.
+func State(state types.ResourceState) Expectation {
+	return func(res types.Resource, err error) error {
+		if res.State != state {
+			return fmt.Errorf("expected resource %q in %s, got %s", res.ID, state, res.State)
+		}
+		return nil
+	}
+}
+
+// Meta asserts the meta of the resource has the key with the value, compared by their formatted strings,
+// so that numbers decoded from json are equal to ints.
+func Meta(key string, value interface{}) Expectation {
+	return func(res types.Resource, err error) error {
+		v, ok := res.Meta[key]
+		if !ok {
+			return fmt.Errorf("expected resource %q with meta %q", res.ID, key)
+		}
+		if fmt.Sprint(v) != fmt.Sprint(value) {
+			return fmt.Errorf("expected meta %q of resource %q to be %v, got %v", key, res.ID, value, v)
+		}
+		return nil
+	}
+}
+
+// Check makes an Expectation from a function checking the resource only.
+func Check(fn func(res types.Resource) error) Expectation {
+	return func(res types.Resource, err error) error {
+		return fn(res)
+	}
+}
@@ -0,0 +1,69 @@
+package plugintest
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/types"
+)
+
+var _ = Describe("Harness", func() {
+	var path string
+	var cleanup func()
+	var harness *Harness
+
+	BeforeEach(func() {
+		var err error
+		path, cleanup, err = Build("github.com/rueian/godemand/plugin/mock/server")
+		Expect(err).NotTo(HaveOccurred())
+		harness, err = Launch(types.CmdParam{Path: path, Pools: []string{"pool1"}})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		harness.Close()
+		cleanup()
+	})
+
+	It("run the scenario and keep the pool", func() {
+		var id string
+		Expect(harness.Run(
+			Step{Name: "create", Expect: []Expectation{NoError(), State(types.ResourcePending), Check(func(res types.Resource) error {
+				id = res.ID
+				return nil
+			})}},
+		)).NotTo(HaveOccurred())
+		Expect(harness.Pool.Resources).To(HaveKey(id))
+		Expect(harness.Pool.Resources[id].PoolID).To(Equal("pool1"))
+
+		Expect(harness.Run(
+			Step{Name: "reuse", Expect: []Expectation{NoError(), ID(id)}},
+			Step{Name: "boot", ResourceID: id, Params: map[string]interface{}{"state": float64(types.ResourceServing)}, Expect: []Expectation{State(types.ResourceServing)}},
+			Step{Name: "fail", Params: map[string]interface{}{"err": "boom"}, Expect: []Expectation{ErrorContains("boom")}},
+			Step{Name: "delete", ResourceID: id, Params: map[string]interface{}{"state": float64(types.ResourceDeleted)}, Expect: []Expectation{NoError()}},
+		)).NotTo(HaveOccurred())
+		Expect(harness.Pool.Resources).To(BeEmpty())
+		Expect(harness.Logs()).NotTo(BeEmpty())
+	})
+
+	It("reject invalid responses like the api", func() {
+		Expect(harness.Run(
+			Step{Params: map[string]interface{}{"ret": "unknown"}, Expect: []Expectation{ErrorIs(types.InvalidResponseErr)}},
+			Step{ResourceID: "unknown", Expect: []Expectation{ErrorIs(ResourceNotInPoolErr)}},
+		)).NotTo(HaveOccurred())
+	})
+
+	It("report the failed step", func() {
+		err := harness.Run(
+			Step{Expect: []Expectation{NoError()}},
+			Step{Name: "wrong", Expect: []Expectation{ID("other")}},
+		)
+		Expect(err).To(MatchError(ContainSubstring("step wrong: expected resource \"other\"")))
+		Expect(errors.Unwrap(err)).NotTo(BeNil())
+	})
+
+	It("run the steps in go tests", func() {
+		Test(GinkgoT(), types.CmdParam{Path: path}, Step{Expect: []Expectation{NoError()}})
+	})
+})
@@ -0,0 +1,13 @@
+package plugintest
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestPlugintest(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Plugintest Suite")
+}
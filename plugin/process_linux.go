@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/rueian/godemand/types"
+	"golang.org/x/sys/unix"
+)
+
+func sysProcAttr(param types.CmdParam) (*syscall.SysProcAttr, error) {
+	if param.Credential == nil {
+		return nil, nil
+	}
+	return &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: param.Credential.UID, Gid: param.Credential.GID},
+	}, nil
+}
+
+// rlimitsEnv makes a process importing this package apply the rlimits in its value and then exec its args.
+const rlimitsEnv = "GODEMAND_PLUGIN_RLIMITS"
+
+func init() {
+	if spec, ok := os.LookupEnv(rlimitsEnv); ok {
+		execLimited(spec, os.Args[1:])
+	}
+}
+
+// limitExec makes the cmd start by re-executing the current binary, which applies the rlimits by setrlimit(2)
+// and then replaces itself by the plugin, so that the plugin runs under the rlimits from its first instruction.
+func limitExec(cmd *exec.Cmd, limits types.Rlimits) {
+	if limits == (types.Rlimits{}) {
+		return
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(env[:len(env):len(env)], fmt.Sprintf("%s=%d:%d:%d", rlimitsEnv, limits.Memory, limits.CPU, limits.NoFile))
+	cmd.Args = append([]string{rlimitsEnv, cmd.Path}, cmd.Args...)
+	cmd.Path = "/proc/self/exe"
+}
+
+// execLimited never returns, and the plugin is not executed if any of the rlimits can't be applied.
+func execLimited(spec string, args []string) {
+	os.Unsetenv(rlimitsEnv)
+	err := setRlimits(spec)
+	if err == nil && len(args) < 2 {
+		err = fmt.Errorf("missing plugin path in args %q", args)
+	}
+	if err == nil {
+		err = syscall.Exec(args[0], args[1:], os.Environ())
+	}
+	fmt.Fprintf(os.Stderr, "fail to exec plugin with rlimits %s: %v\n", spec, err)
+	os.Exit(126)
+}
+
+func setRlimits(spec string) error {
+	var limits types.Rlimits
+	if _, err := fmt.Sscanf(spec, "%d:%d:%d", &limits.Memory, &limits.CPU, &limits.NoFile); err != nil {
+		return err
+	}
+	for resource, limit := range map[int]uint64{
+		unix.RLIMIT_AS:     limits.Memory,
+		unix.RLIMIT_CPU:    limits.CPU,
+		unix.RLIMIT_NOFILE: limits.NoFile,
+	} {
+		if limit == 0 {
+			continue
+		}
+		// syscall.Setrlimit also stops the go runtime from restoring its original RLIMIT_NOFILE on exec
+		if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: limit, Max: limit}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pinExec makes the cmd execute the opened file instead of its path, which may be replaced after the file is verified.
+// The file is passed to the process as an extra fd, so that the interpreter of a script can still read it by the fd path.
+func pinExec(cmd *exec.Cmd, f *os.File) {
+	cmd.ExtraFiles = append(cmd.ExtraFiles, f)
+	cmd.Path = fmt.Sprintf("/proc/self/fd/%d", 2+len(cmd.ExtraFiles))
+}
@@ -0,0 +1,91 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/types"
+)
+
+var _ = Describe("PluginLauncher on linux", func() {
+	var launcher *Launcher
+	var err error
+
+	BeforeEach(func() {
+		launcher = NewLauncher(types.CmdParam{
+			Name:    "PuppetController",
+			Path:    "./mock/server/puppet",
+			Rlimits: types.Rlimits{NoFile: 64, CPU: 100, Memory: 4 << 30},
+		}, nil)
+		_, err = launcher.Launch()
+	})
+
+	AfterEach(func() {
+		launcher.Close()
+	})
+
+	It("apply rlimits", func() {
+		Expect(err).NotTo(HaveOccurred())
+		limits, err := ioutil.ReadFile("/proc/" + strconv.Itoa(launcher.command.Process.Pid) + "/limits")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(limits)).To(MatchRegexp(`Max open files\s+64\s+64`))
+		Expect(string(limits)).To(MatchRegexp(`Max cpu time\s+100\s+100`))
+		Expect(string(limits)).To(MatchRegexp(`Max address space\s+4294967296\s+4294967296`))
+	})
+})
+
+var _ = Describe("limitExec", func() {
+	It("apply rlimits before the plugin starts", func() {
+		dir, _ := ioutil.TempDir("", "rlimits")
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "script")
+		Expect(ioutil.WriteFile(path, []byte("#!/bin/sh\necho \"{\\\"ID\\\":\\\"$(ulimit -n)\\\"}\"\n"), 0755)).NotTo(HaveOccurred())
+		controller, err := NewExecController(types.CmdParam{Name: "script", Path: path, Kind: types.KindExec, Rlimits: types.Rlimits{NoFile: 32}}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		res, err := controller.FindResource(types.ResourcePool{}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res.ID).To(Equal("32"))
+	})
+
+	It("not start the plugin if the rlimits can't be applied", func() {
+		cmd := exec.Command("/bin/true")
+		limitExec(cmd, types.Rlimits{NoFile: 32})
+		cmd.Env = append(os.Environ(), rlimitsEnv+"=malformed")
+		out, err := cmd.CombinedOutput()
+		Expect(err).To(HaveOccurred())
+		Expect(string(out)).To(ContainSubstring("fail to exec plugin with rlimits"))
+	})
+})
+
+var _ = Describe("pinExec", func() {
+	It("execute the verified file even if the path is replaced", func() {
+		dir, _ := ioutil.TempDir("", "pin")
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "script")
+		Expect(ioutil.WriteFile(path, []byte(execScript), 0755)).NotTo(HaveOccurred())
+		controller, err := NewExecController(types.CmdParam{Name: "script", Path: path, Kind: types.KindExec}, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		bin, file, err := controller.verified()
+		Expect(err).NotTo(HaveOccurred())
+		defer file.Close()
+		Expect(ioutil.WriteFile(path+".new", []byte("#!/bin/sh\necho replaced\n"), 0755)).NotTo(HaveOccurred())
+		Expect(os.Rename(path+".new", path)).NotTo(HaveOccurred())
+
+		cmd := exec.Command(bin.Path, "-v", "FindResource")
+		pinExec(cmd, file)
+		cmd.Stdin = strings.NewReader("{}")
+		out, err := cmd.Output()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(out)).To(ContainSubstring(`"ID":"found"`))
+
+		_, err = controller.FindResource(types.ResourcePool{}, nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,28 @@
+//go:build !linux
+// +build !linux
+
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/rueian/godemand/types"
+)
+
+func sysProcAttr(param types.CmdParam) (*syscall.SysProcAttr, error) {
+	if param.Credential != nil {
+		return nil, fmt.Errorf("fail to run plugin %s as uid %d: %w", param.Name, param.Credential.UID, ProcessAttrNotSupportedErr)
+	}
+	if param.Rlimits != (types.Rlimits{}) {
+		return nil, fmt.Errorf("fail to set rlimits of plugin %s: %w", param.Name, ProcessAttrNotSupportedErr)
+	}
+	return nil, nil
+}
+
+func limitExec(cmd *exec.Cmd, limits types.Rlimits) {}
+
+// pinExec is not supported on this platform, therefore the path is executed and may be replaced after the file is verified.
+func pinExec(cmd *exec.Cmd, f *os.File) {}
@@ -2,13 +2,15 @@ package plugin
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net"
 	"net/rpc"
 	"os"
 
+	"github.com/rueian/godemand/tracing"
 	"github.com/rueian/godemand/types"
+	"go.opencensus.io/trace"
+	"go.opencensus.io/trace/propagation"
 )
 
 const ListenedSign = "PLUGIN_LISTENED"
@@ -16,15 +18,26 @@ const ListenedSign = "PLUGIN_LISTENED"
 type FindResourceArgs struct {
 	Pool   types.ResourcePool
 	Params map[string]interface{}
+	Delta  *PoolDelta `json:",omitempty"`
+	// Trace is the span context of the caller in the binary propagation format.
+	Trace []byte `json:",omitempty"`
 }
 
 type SyncResourceArgs struct {
 	Resource types.Resource
 	Params   map[string]interface{}
+	Trace    []byte `json:",omitempty"`
+}
+
+type ListExternalArgs struct {
+	Pool   types.ResourcePool
+	Params map[string]interface{}
+	Delta  *PoolDelta `json:",omitempty"`
 }
 
 type Server struct {
 	controller types.Controller
+	pools      poolCache
 }
 
 func (*Server) ProtocolVersion(args *int, reply *int) error {
@@ -32,34 +45,97 @@ func (*Server) ProtocolVersion(args *int, reply *int) error {
 	return nil
 }
 
-func (s *Server) FindResource(args *[]byte, reply *[]byte) error {
-	var a FindResourceArgs
-	if err := json.Unmarshal(*args, &a); err != nil {
-		return err
-	}
-	res, err := s.controller.FindResource(a.Pool, a.Params)
-	if err == nil {
-		*reply, err = json.Marshal(res)
-	}
-	if err != nil {
-		return err
-	}
+// Encodings replies the encodings supported by the Call.
+func (*Server) Encodings(args *int, reply *[]string) error {
+	*reply = encodings()
 	return nil
 }
 
-func (s *Server) SyncResource(args *[]byte, reply *[]byte) error {
-	var a SyncResourceArgs
-	if err := json.Unmarshal(*args, &a); err != nil {
-		return err
+// Call serves the method of the request in its encoding.
+func (s *Server) Call(req *Request, reply *[]byte) (err error) {
+	c, ok := codecs[req.Encoding]
+	if !ok {
+		return fmt.Errorf("fail to call %s in %q: %w", req.Method, req.Encoding, UnknownEncodingErr)
 	}
-	res, err := s.controller.SyncResource(a.Resource, a.Params)
-	if err == nil {
-		*reply, err = json.Marshal(res)
+	*reply, err = s.serve(req.Method, c, req.Args)
+	return
+}
+
+func (s *Server) FindResource(args *[]byte, reply *[]byte) (err error) {
+	*reply, err = s.serve("FindResource", codecs[types.EncodingJSON], *args)
+	return
+}
+
+func (s *Server) SyncResource(args *[]byte, reply *[]byte) (err error) {
+	*reply, err = s.serve("SyncResource", codecs[types.EncodingJSON], *args)
+	return
+}
+
+func (s *Server) ListExternal(args *[]byte, reply *[]byte) (err error) {
+	*reply, err = s.serve("ListExternal", codecs[types.EncodingJSON], *args)
+	return
+}
+
+func (s *Server) serve(method string, c codec, args []byte) ([]byte, error) {
+	switch method {
+	case "FindResource":
+		var a FindResourceArgs
+		if err := c.unmarshal(args, &a); err != nil {
+			return nil, err
+		}
+		pool, err := s.pools.apply(a.Pool, a.Delta)
+		if err != nil {
+			return nil, err
+		}
+		ctx, span := serveSpan(method, a.Trace)
+		res, err := types.FindResource(ctx, s.controller, pool, a.Params)
+		tracing.End(span, err)
+		if err != nil {
+			return nil, err
+		}
+		return c.marshal(res)
+	case "SyncResource":
+		var a SyncResourceArgs
+		if err := c.unmarshal(args, &a); err != nil {
+			return nil, err
+		}
+		ctx, span := serveSpan(method, a.Trace)
+		res, err := types.SyncResource(ctx, s.controller, a.Resource, a.Params)
+		tracing.End(span, err)
+		if err != nil {
+			return nil, err
+		}
+		return c.marshal(res)
+	case "ListExternal":
+		lister, ok := s.controller.(types.ExternalLister)
+		if !ok {
+			return nil, types.ExternalListNotSupportedErr
+		}
+		var a ListExternalArgs
+		if err := c.unmarshal(args, &a); err != nil {
+			return nil, err
+		}
+		pool, err := s.pools.apply(a.Pool, a.Delta)
+		if err != nil {
+			return nil, err
+		}
+		res, err := lister.ListExternal(pool, a.Params)
+		if err != nil {
+			return nil, err
+		}
+		return c.marshal(res)
 	}
-	if err != nil {
-		return err
+	return nil, fmt.Errorf("rpc: can't find method %s", method)
+}
+
+// serveSpan starts the server span as the child of the caller span if the caller is traced,
+// so that the ContextController can add its own spans into the trace.
+func serveSpan(method string, parent []byte) (context.Context, *trace.Span) {
+	kind := trace.WithSpanKind(trace.SpanKindServer)
+	if sc, ok := propagation.FromBinary(parent); ok {
+		return trace.StartSpanWithRemoteParent(context.Background(), tracing.SpanPluginServe+method, sc, kind)
 	}
-	return nil
+	return trace.StartSpan(context.Background(), tracing.SpanPluginServe+method, kind)
 }
 
 func Serve(ctx context.Context, controller types.Controller) error {
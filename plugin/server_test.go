@@ -15,8 +15,12 @@ import (
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/metrics"
 	"github.com/rueian/godemand/types"
 	"github.com/rueian/godemand/types/mock"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
 )
 
 var _ = Describe("Server", func() {
@@ -128,6 +132,173 @@ var _ = Describe("Server", func() {
 	})
 })
 
+var _ = Describe("Server ListExternal", func() {
+	var ctrl *gomock.Controller
+	var server *Server
+	var pool types.ResourcePool
+	var params map[string]interface{}
+	var in, out []byte
+	var err error
+
+	BeforeEach(func() {
+		ctrl = gomock.NewController(GinkgoT())
+		pool = types.ResourcePool{ID: "a", Resources: map[string]types.Resource{}}
+		params = makeMeta()
+		in, _ = json.Marshal(ListExternalArgs{Pool: pool, Params: params})
+		out = nil
+	})
+
+	AfterEach(func() {
+		ctrl.Finish()
+	})
+
+	Context("controller without ListExternal", func() {
+		BeforeEach(func() {
+			server = &Server{controller: mock.NewMockController(ctrl)}
+			err = server.ListExternal(&in, &out)
+		})
+		It("return ExternalListNotSupportedErr", func() {
+			Expect(err).To(Equal(types.ExternalListNotSupportedErr))
+		})
+	})
+
+	Context("controller with ListExternal", func() {
+		var ret []types.Resource
+
+		BeforeEach(func() {
+			lister := mock.NewMockExternalLister(ctrl)
+			server = &Server{controller: struct {
+				*mock.MockController
+				*mock.MockExternalLister
+			}{mock.NewMockController(ctrl), lister}}
+			lister.EXPECT().ListExternal(pool, params).Return([]types.Resource{makeResource()}, nil)
+			err = server.ListExternal(&in, &out)
+			Expect(json.Unmarshal(out, &ret)).NotTo(HaveOccurred())
+		})
+		It("convert args and returns", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ret).To(HaveLen(1))
+		})
+	})
+})
+
+// tracedController keeps the span contexts of the calls it received.
+type tracedController struct {
+	poolController
+	spans []trace.SpanContext
+}
+
+func (c *tracedController) FindResourceContext(ctx context.Context, pool types.ResourcePool, params map[string]interface{}) (types.Resource, error) {
+	c.spans = append(c.spans, trace.FromContext(ctx).SpanContext())
+	return c.FindResource(pool, params)
+}
+
+func (c *tracedController) SyncResourceContext(ctx context.Context, resource types.Resource, params map[string]interface{}) (types.Resource, error) {
+	c.spans = append(c.spans, trace.FromContext(ctx).SpanContext())
+	return c.SyncResource(resource, params)
+}
+
+var _ = Describe("Server tracing", func() {
+	It("serve calls in the trace of the caller", func() {
+		controller := &tracedController{}
+		for _, encoding := range []string{"", types.EncodingMsgpack} {
+			client := pipeClient(controller, encoding, "")
+			ctx, span := trace.StartSpan(context.Background(), "caller", trace.WithSampler(trace.AlwaysSample()))
+			_, err := types.FindResource(ctx, client, types.ResourcePool{ID: "pool"}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = types.SyncResource(ctx, client, types.Resource{ID: "a", PoolID: "pool"}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			span.End()
+			client.client.Close()
+
+			Expect(controller.spans).To(HaveLen(2))
+			for _, sc := range controller.spans {
+				Expect(sc.TraceID).To(Equal(span.SpanContext().TraceID))
+				Expect(sc.IsSampled()).To(BeTrue())
+			}
+			controller.spans = nil
+		}
+	})
+
+	It("serve untraced calls in new traces", func() {
+		controller := &tracedController{}
+		client := pipeClient(controller, types.EncodingJSON, "")
+		defer client.client.Close()
+		_, err := client.FindResource(types.ResourcePool{ID: "pool"}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(controller.spans).To(HaveLen(1))
+		Expect(controller.spans[0].TraceID).NotTo(BeZero())
+	})
+})
+
+var _ = Describe("observed metrics", func() {
+	BeforeEach(func() {
+		Expect(view.Register(metrics.PluginCallView, metrics.PluginErrorView)).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		view.Unregister(metrics.PluginCallView, metrics.PluginErrorView)
+	})
+
+	It("count calls by plugin, pool, method and outcome", func() {
+		ctrl := gomock.NewController(GinkgoT())
+		defer ctrl.Finish()
+		controller := mock.NewMockController(ctrl)
+		controller.EXPECT().FindResource(gomock.Any(), gomock.Any()).Return(types.Resource{ID: "a"}, nil)
+		controller.EXPECT().SyncResource(gomock.Any(), gomock.Any()).Return(types.Resource{}, errors.New("any"))
+
+		rpc := pipeClient(controller, types.EncodingJSON, "")
+		client := &observed{name: "counted", next: rpc, outcome: rpcOutcome}
+		client.FindResource(types.ResourcePool{ID: "pool1"}, nil)
+		client.SyncResource(types.Resource{ID: "a", PoolID: "pool1"}, nil)
+		rpc.client.Close()
+		client.SyncResource(types.Resource{ID: "a", PoolID: "pool1"}, nil)
+
+		Expect(pluginCalls("counted", metrics.PluginCallView, "FindResource", metrics.OutcomeOK)).To(Equal(int64(1)))
+		Expect(pluginCalls("counted", metrics.PluginCallView, "SyncResource", metrics.OutcomeError)).To(Equal(int64(1)))
+		Expect(pluginCalls("counted", metrics.PluginErrorView, "SyncResource", metrics.OutcomeError)).To(Equal(int64(1)))
+		Expect(pluginCalls("counted", metrics.PluginErrorView, "SyncResource", metrics.OutcomeUnavailable)).To(Equal(int64(1)))
+		Expect(pluginCalls("counted", metrics.PluginErrorView, "FindResource", metrics.OutcomeOK)).To(BeZero())
+	})
+
+	It("count calls of in process plugins and ListExternal", func() {
+		ctrl := gomock.NewController(GinkgoT())
+		defer ctrl.Finish()
+		controller := mock.NewMockController(ctrl)
+		controller.EXPECT().FindResource(gomock.Any(), gomock.Any()).Return(types.Resource{}, errors.New("any"))
+		RegisterBuiltin("counted", controller)
+		defer UnregisterBuiltin("counted")
+
+		launcher := NewLauncher(types.CmdParam{Name: "local", Path: "builtin:counted"}, nil)
+		client, err := launcher.Launch()
+		Expect(err).NotTo(HaveOccurred())
+		client.FindResource(types.ResourcePool{ID: "pool1"}, nil)
+		client.(types.ExternalLister).ListExternal(types.ResourcePool{ID: "pool1"}, nil)
+		launcher.Close()
+		Eventually(launcher.exited).Should(BeClosed())
+		client.SyncResource(types.Resource{ID: "a", PoolID: "pool1"}, nil)
+
+		Expect(pluginCalls("local", metrics.PluginErrorView, "FindResource", metrics.OutcomeError)).To(Equal(int64(1)))
+		Expect(pluginCalls("local", metrics.PluginErrorView, "ListExternal", metrics.OutcomeError)).To(Equal(int64(1)))
+		Expect(pluginCalls("local", metrics.PluginErrorView, "SyncResource", metrics.OutcomeUnavailable)).To(Equal(int64(1)))
+	})
+})
+
+func pluginCalls(plugin string, v *view.View, method, outcome string) int64 {
+	rows, err := view.RetrieveData(v.Name)
+	Expect(err).NotTo(HaveOccurred())
+	for _, row := range rows {
+		tags := map[tag.Key]string{}
+		for _, t := range row.Tags {
+			tags[t.Key] = t.Value
+		}
+		if tags[metrics.KeyPlugin] == plugin && tags[metrics.KeyPool] == "pool1" && tags[metrics.KeyMethod] == method && tags[metrics.KeyOutcome] == outcome {
+			return row.Data.(*view.CountData).Value
+		}
+	}
+	return 0
+}
+
 var _ = Describe("Serve", func() {
 	var ctrl *gomock.Controller
 	var controller *mock.MockController
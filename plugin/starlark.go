@@ -0,0 +1,288 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/rueian/godemand/types"
+	starjson "go.starlark.net/lib/json"
+	startime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+const (
+	DefaultStarlarkTimeout  = 10 * time.Second
+	DefaultStarlarkMaxSteps = 10000000
+)
+
+var StarlarkFunctionNotFoundErr = errors.New("starlark script doesn't define the function")
+
+// StarlarkController implements types.Controller by the functions defined in a starlark script:
+//
+//	def find_resource(pool, params): return resource
+//	def sync_resource(resource, params): return resource
+//	def list_external(pool, params): return [resource]    # optional
+//
+// The pool, resource and params are passed as dicts, with time fields as time values of the time module,
+// and the returned dicts are decoded into types.Resource. The script can use the predeclared json, time and states
+// modules, but can't load other files, and time.now() returns the same time during a call to keep it deterministic.
+// Each call is limited by the number of execution steps and the timeout.
+type StarlarkController struct {
+	param    types.CmdParam
+	sink     *LogSink
+	globals  starlark.StringDict
+	maxSteps uint64
+	timeout  time.Duration
+}
+
+func NewStarlarkController(param types.CmdParam, sink *LogSink) (*StarlarkController, error) {
+	src, filename := param.Starlark.Source, param.Name+".star"
+	if src == "" {
+		bs, err := ioutil.ReadFile(param.Path)
+		if err != nil {
+			return nil, err
+		}
+		src, filename = string(bs), param.Path
+	}
+	return newStarlarkController(param, sink, src, filename)
+}
+
+func newStarlarkController(param types.CmdParam, sink *LogSink, src, filename string) (*StarlarkController, error) {
+	var err error
+	if sink == nil {
+		if sink, err = NewLogSink(param.Name, param.Logs, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &StarlarkController{param: param, sink: sink, maxSteps: param.Starlark.MaxSteps, timeout: param.Starlark.Timeout}
+	if c.maxSteps == 0 {
+		c.maxSteps = DefaultStarlarkMaxSteps
+	}
+	if c.timeout <= 0 {
+		c.timeout = DefaultStarlarkTimeout
+	}
+
+	thread := c.thread(time.Now())
+	timer := c.deadline(thread)
+	c.globals, err = starlark.ExecFile(thread, filename, src, predeclared())
+	timer.Stop()
+	if err != nil {
+		return nil, fmt.Errorf("fail to load starlark script of plugin %s: %w", param.Name, err)
+	}
+	c.globals.Freeze()
+	return c, nil
+}
+
+func (c *StarlarkController) FindResource(pool types.ResourcePool, params map[string]interface{}) (res types.Resource, err error) {
+	err = c.call("find_resource", pool, params, &res)
+	return
+}
+
+func (c *StarlarkController) SyncResource(resource types.Resource, params map[string]interface{}) (res types.Resource, err error) {
+	err = c.call("sync_resource", resource, params, &res)
+	return
+}
+
+func (c *StarlarkController) ListExternal(pool types.ResourcePool, params map[string]interface{}) (res []types.Resource, err error) {
+	err = c.call("list_external", pool, params, &res)
+	if errors.Is(err, StarlarkFunctionNotFoundErr) {
+		err = fmt.Errorf("fail to list external resources: %w", types.ExternalListNotSupportedErr)
+	}
+	return
+}
+
+func (c *StarlarkController) call(name string, arg interface{}, params map[string]interface{}, reply interface{}) error {
+	fn, ok := c.globals[name].(starlark.Callable)
+	if !ok {
+		return fmt.Errorf("fail to call %s of plugin %s: %w", name, c.param.Name, StarlarkFunctionNotFoundErr)
+	}
+
+	thread := c.thread(time.Now())
+	defer c.deadline(thread).Stop()
+
+	sarg, err := toStarlark(reflect.ValueOf(arg))
+	if err != nil {
+		return err
+	}
+	sparams, err := toStarlark(reflect.ValueOf(params))
+	if err != nil {
+		return err
+	}
+	ret, err := starlark.Call(thread, fn, starlark.Tuple{sarg, sparams}, nil)
+	if err != nil {
+		return fmt.Errorf("fail to call %s of plugin %s: %w", name, c.param.Name, err)
+	}
+
+	out, err := fromStarlark(ret)
+	if err != nil {
+		return fmt.Errorf("fail to convert the result of %s of plugin %s: %w", name, c.param.Name, err)
+	}
+	bs, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bs, reply)
+}
+
+// deadline cancels the thread once the timeout is passed, unless the returned timer is stopped.
+func (c *StarlarkController) deadline(thread *starlark.Thread) *time.Timer {
+	return time.AfterFunc(c.timeout, func() {
+		thread.Cancel(fmt.Sprintf("timeout after %s", c.timeout))
+	})
+}
+
+func (c *StarlarkController) thread(now time.Time) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: c.param.Name,
+		Print: func(_ *starlark.Thread, msg string) {
+			c.sink.Write(0, "print", msg)
+		},
+	}
+	thread.SetLocal("now", now)
+	thread.SetMaxExecutionSteps(c.maxSteps)
+	return thread
+}
+
+func predeclared() starlark.StringDict {
+	members := make(starlark.StringDict, len(startime.Module.Members))
+	for k, v := range startime.Module.Members {
+		members[k] = v
+	}
+	members["now"] = starlark.NewBuiltin("now", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		return startime.Time(thread.Local("now").(time.Time)), nil
+	})
+
+	states := make(starlark.StringDict, len(types.ResourceStates))
+	for _, s := range types.ResourceStates {
+		states[s.String()] = starlark.MakeInt(int(s))
+	}
+
+	return starlark.StringDict{
+		"json":   starjson.Module,
+		"time":   &starlarkstruct.Module{Name: "time", Members: members},
+		"states": &starlarkstruct.Module{Name: "states", Members: states},
+	}
+}
+
+// toStarlark converts the go value into dicts, lists and primitives, where structs are converted into dicts by field names.
+func toStarlark(v reflect.Value) (starlark.Value, error) {
+	if !v.IsValid() {
+		return starlark.None, nil
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return startime.Time(t), nil
+	}
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return starlark.None, nil
+		}
+		return toStarlark(v.Elem())
+	case reflect.Bool:
+		return starlark.Bool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return starlark.MakeInt64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return starlark.MakeUint64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return starlark.Float(v.Float()), nil
+	case reflect.String:
+		return starlark.String(v.String()), nil
+	case reflect.Slice, reflect.Array:
+		list := make([]starlark.Value, v.Len())
+		for i := range list {
+			e, err := toStarlark(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			list[i] = e
+		}
+		return starlark.NewList(list), nil
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+		dict := starlark.NewDict(len(keys))
+		for _, k := range keys {
+			e, err := toStarlark(v.MapIndex(k))
+			if err != nil {
+				return nil, err
+			}
+			dict.SetKey(starlark.String(fmt.Sprint(k)), e)
+		}
+		return dict, nil
+	case reflect.Struct:
+		dict := starlark.NewDict(v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			e, err := toStarlark(v.Field(i))
+			if err != nil {
+				return nil, err
+			}
+			dict.SetKey(starlark.String(v.Type().Field(i).Name), e)
+		}
+		return dict, nil
+	}
+	return nil, fmt.Errorf("fail to convert %s into starlark", v.Type())
+}
+
+// fromStarlark converts the starlark value back into go values which can be marshaled into json.
+func fromStarlark(v starlark.Value) (interface{}, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.Int:
+		i, ok := v.Int64()
+		if !ok {
+			return nil, fmt.Errorf("int %s out of range", v)
+		}
+		return i, nil
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.String:
+		return string(v), nil
+	case startime.Time:
+		return time.Time(v), nil
+	case startime.Duration:
+		return time.Duration(v), nil
+	case *starlark.List:
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			e, err := fromStarlark(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			list[i] = e
+		}
+		return list, nil
+	case starlark.Tuple:
+		return fromStarlark(starlark.NewList(v))
+	case *starlark.Dict:
+		dict := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			k, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("dict key %s is not a string", item[0])
+			}
+			e, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			dict[k] = e
+		}
+		return dict, nil
+	}
+	return nil, fmt.Errorf("unsupported starlark type %s", v.Type())
+}
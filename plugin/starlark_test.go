@@ -0,0 +1,161 @@
+package plugin
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/types"
+	"go.starlark.net/starlark"
+)
+
+const starlarkScript = `
+def find_resource(pool, params):
+    ready = [r for r in pool["Resources"].values() if r["State"] == states.serving]
+    if ready:
+        return sorted(ready, key=lambda r: len(r["Clients"]))[0]
+    print("create for", pool["ID"])
+    return {"ID": "new", "PoolID": pool["ID"], "State": states.pending, "Meta": json.decode(params["meta"])}
+
+def sync_resource(resource, params):
+    if resource["ID"] == "bad":
+        fail("bad resource")
+    if resource["ID"] == "loop":
+        for i in range(1000000000):
+            pass
+    first = time.now()
+    resource["LastSynced"] = time.now()
+    resource["Meta"] = {"same": first == resource["LastSynced"], "age": (time.now() - resource["CreatedAt"]).seconds > 0}
+    return resource
+`
+
+var _ = Describe("StarlarkController", func() {
+	var param types.CmdParam
+	var controller *StarlarkController
+	var err error
+
+	BeforeEach(func() {
+		param = types.CmdParam{Name: "star", Kind: types.KindStarlark, Starlark: types.StarlarkConfig{Source: starlarkScript}}
+	})
+
+	JustBeforeEach(func() {
+		controller, err = NewStarlarkController(param, nil)
+	})
+
+	It("pick the running resource with the least clients", func() {
+		Expect(err).NotTo(HaveOccurred())
+		res, err := controller.FindResource(types.ResourcePool{ID: "pool1", Resources: map[string]types.Resource{
+			"a": {ID: "a", State: types.ResourceServing, Clients: map[string]types.Client{"1": {}, "2": {}}},
+			"b": {ID: "b", State: types.ResourceServing, Clients: map[string]types.Client{"1": {}}},
+			"c": {ID: "c", State: types.ResourcePending},
+		}}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res.ID).To(Equal("b"))
+	})
+
+	It("create a resource with the json module and print to logs", func() {
+		res, err := controller.FindResource(types.ResourcePool{ID: "pool1"}, map[string]interface{}{"meta": `{"a":1}`})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res).To(Equal(types.Resource{ID: "new", PoolID: "pool1", State: types.ResourcePending, Meta: map[string]interface{}{"a": float64(1)}}))
+		Expect(controller.sink.Lines()[0].Text).To(Equal("create for pool1"))
+	})
+
+	It("fix time.now() during a call", func() {
+		res, err := controller.SyncResource(types.Resource{ID: "a", CreatedAt: time.Now().Add(-time.Minute)}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res.Meta).To(Equal(types.Meta{"same": true, "age": true}))
+		Expect(res.LastSynced).To(BeTemporally("~", time.Now(), time.Second))
+	})
+
+	It("return the failure of the script", func() {
+		_, err := controller.SyncResource(types.Resource{ID: "bad"}, nil)
+		var eerr *starlark.EvalError
+		Expect(errors.As(err, &eerr)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("bad resource"))
+	})
+
+	It("get ExternalListNotSupportedErr without list_external", func() {
+		_, err := controller.ListExternal(types.ResourcePool{ID: "pool1"}, nil)
+		Expect(errors.Is(err, types.ExternalListNotSupportedErr)).To(BeTrue())
+	})
+
+	Context("with step limit", func() {
+		BeforeEach(func() {
+			param.Starlark.MaxSteps = 1000
+		})
+		It("stop the endless call", func() {
+			_, err := controller.SyncResource(types.Resource{ID: "loop"}, nil)
+			Expect(err).To(MatchError(ContainSubstring("too many steps")))
+		})
+	})
+
+	Context("with time limit", func() {
+		BeforeEach(func() {
+			param.Starlark.Timeout = 100 * time.Millisecond
+			param.Starlark.MaxSteps = 1 << 62
+		})
+		It("stop the endless call", func() {
+			_, err := controller.SyncResource(types.Resource{ID: "loop"}, nil)
+			Expect(err).To(MatchError(ContainSubstring("timeout after")))
+		})
+
+		Context("while loading", func() {
+			BeforeEach(func() {
+				param.Starlark.Source = starlarkScript + `
+sync_resource({"ID": "loop"}, {})
+`
+			})
+			It("stop the endless script", func() {
+				Expect(err).To(MatchError(ContainSubstring("timeout after")))
+			})
+		})
+	})
+
+	Context("with invalid script", func() {
+		BeforeEach(func() {
+			param.Starlark.Source = `load("other.star", "x")`
+		})
+		It("fail to load", func() {
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("script file", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "starlark")
+			param.Path = filepath.Join(dir, "controller.star")
+			param.Starlark.Source = ""
+			Expect(ioutil.WriteFile(param.Path, []byte(starlarkScript), 0644)).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("load the script from the path", func() {
+			Expect(err).NotTo(HaveOccurred())
+			res, err := controller.FindResource(types.ResourcePool{ID: "pool1"}, map[string]interface{}{"meta": "{}"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.ID).To(Equal("new"))
+		})
+
+		It("serve the script as a plugin", func() {
+			launchpad := NewLaunchpad()
+			defer launchpad.Close()
+			Expect(launchpad.SetLaunchers(map[string]types.CmdParam{"star": param})).NotTo(HaveOccurred())
+
+			c, err := launchpad.GetController("star")
+			Expect(err).NotTo(HaveOccurred())
+			res, err := c.SyncResource(types.Resource{ID: "a"}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.ID).To(Equal("a"))
+			Expect(launchpad.Status()[0].Checksum).NotTo(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,320 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rueian/godemand/types"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+const (
+	DefaultWasmTimeout = 30 * time.Second
+	WasmHostModule     = "godemand"
+	wasmPageSize       = 65536
+)
+
+var (
+	WasmExportNotFoundErr = errors.New("wasm module doesn't export the function")
+	WasmMemoryErr         = errors.New("wasm module returns out of range memory")
+	WasmEgressDeniedErr   = errors.New("http egress not allowed for the wasm module")
+)
+
+// WasmController implements types.Controller by a wasm module, which is instantiated for each call in a sandbox
+// with the wasi imports but no file system, and the host functions of the "godemand" module:
+//
+//	log(ptr, len i32)                 writes the string to the plugin logs
+//	http_request(ptr, len i32) i64    sends the WasmHTTPRequest in json and returns the WasmHTTPResponse in json
+//
+// The module should export "memory", "alloc(len i32) i32", "find_resource(ptr, len i32) i64",
+// "sync_resource(ptr, len i32) i64" and optionally "list_external(ptr, len i32) i64".
+// The exported functions receive the json args, and return the WasmResult in json, where the i64 packs its pointer
+// in the high 32 bits and its length in the low 32 bits.
+type WasmController struct {
+	param    types.CmdParam
+	sink     *LogSink
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	timeout  time.Duration
+	client   *http.Client
+}
+
+// WasmResult is returned by the exported functions of the module, and a non empty Error fails the call.
+type WasmResult struct {
+	Result json.RawMessage
+	Error  string
+}
+
+type WasmHTTPRequest struct {
+	Method  string
+	URL     string
+	Headers map[string]string
+	Body    string
+}
+
+type WasmHTTPResponse struct {
+	StatusCode int
+	Headers    map[string]string
+	Body       string
+	Error      string
+}
+
+func NewWasmController(param types.CmdParam, sink *LogSink) (*WasmController, error) {
+	code, err := ioutil.ReadFile(param.Path)
+	if err != nil {
+		return nil, err
+	}
+	return newWasmController(param, sink, code)
+}
+
+func newWasmController(param types.CmdParam, sink *LogSink, code []byte) (c *WasmController, err error) {
+	if sink == nil {
+		if sink, err = NewLogSink(param.Name, param.Logs, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	c = &WasmController{param: param, sink: sink, timeout: param.Wasm.Timeout}
+	if c.timeout <= 0 {
+		c.timeout = DefaultWasmTimeout
+	}
+	c.client = &http.Client{Timeout: c.timeout, CheckRedirect: c.redirect}
+
+	config := wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
+	if param.Wasm.MemoryLimit > 0 {
+		pages := param.Wasm.MemoryLimit / wasmPageSize
+		if pages == 0 {
+			pages = 1
+		}
+		config = config.WithMemoryLimitPages(uint32(pages))
+	}
+
+	ctx := context.Background()
+	c.runtime = wazero.NewRuntimeWithConfig(ctx, config)
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, c.runtime); err != nil {
+		c.runtime.Close(ctx)
+		return nil, err
+	}
+	if _, err := c.runtime.NewHostModuleBuilder(WasmHostModule).
+		NewFunctionBuilder().WithFunc(c.log).Export("log").
+		NewFunctionBuilder().WithFunc(c.httpRequest).Export("http_request").
+		Instantiate(ctx); err != nil {
+		c.runtime.Close(ctx)
+		return nil, err
+	}
+	if c.compiled, err = c.runtime.CompileModule(ctx, code); err != nil {
+		c.runtime.Close(ctx)
+		return nil, fmt.Errorf("fail to compile wasm module of plugin %s: %w", param.Name, err)
+	}
+	return c, nil
+}
+
+func (c *WasmController) FindResource(pool types.ResourcePool, params map[string]interface{}) (res types.Resource, err error) {
+	err = c.invoke("find_resource", &FindResourceArgs{Pool: pool, Params: params}, &res)
+	return
+}
+
+func (c *WasmController) SyncResource(resource types.Resource, params map[string]interface{}) (res types.Resource, err error) {
+	err = c.invoke("sync_resource", &SyncResourceArgs{Resource: resource, Params: params}, &res)
+	return
+}
+
+func (c *WasmController) ListExternal(pool types.ResourcePool, params map[string]interface{}) (res []types.Resource, err error) {
+	err = c.invoke("list_external", &ListExternalArgs{Pool: pool, Params: params}, &res)
+	if errors.Is(err, WasmExportNotFoundErr) {
+		err = fmt.Errorf("fail to list external resources: %w", types.ExternalListNotSupportedErr)
+	}
+	return
+}
+
+// Close releases the compiled module and the runtime.
+func (c *WasmController) Close() error {
+	return c.runtime.Close(context.Background())
+}
+
+func (c *WasmController) invoke(export string, args, reply interface{}) error {
+	input, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	output, err := c.call(export, input)
+	if err != nil {
+		return err
+	}
+	var result WasmResult
+	if err := json.Unmarshal(output, &result); err != nil {
+		return fmt.Errorf("fail to decode the result of %s of plugin %s: %w", export, c.param.Name, err)
+	}
+	if result.Error != "" {
+		return fmt.Errorf("fail to call %s of plugin %s: %s", export, c.param.Name, result.Error)
+	}
+	return json.Unmarshal(result.Result, reply)
+}
+
+// call instantiates the module, passes the input to the exported function, and returns its output.
+// The instance is closed and the call fails once the timeout is reached.
+func (c *WasmController) call(export string, input []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	stdout, stderr := &sinkWriter{sink: c.sink, stream: "stdout"}, &sinkWriter{sink: c.sink, stream: "stderr"}
+	mod, err := c.runtime.InstantiateModule(ctx, c.compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize").
+		WithStdout(stdout).
+		WithStderr(stderr))
+	if err != nil {
+		return nil, fmt.Errorf("fail to instantiate wasm module of plugin %s: %w", c.param.Name, err)
+	}
+	defer mod.Close(context.Background())
+
+	fn := mod.ExportedFunction(export)
+	if fn == nil {
+		return nil, fmt.Errorf("fail to call %s of plugin %s: %w", export, c.param.Name, WasmExportNotFoundErr)
+	}
+	ptr, err := write(ctx, mod, input)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := fn.Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("fail to call %s of plugin %s in %s: %w", export, c.param.Name, c.timeout, ctx.Err())
+		}
+		return nil, fmt.Errorf("fail to call %s of plugin %s: %w", export, c.param.Name, err)
+	}
+	return read(mod, ret[0])
+}
+
+func (c *WasmController) log(ctx context.Context, m api.Module, ptr, size uint32) {
+	if msg, ok := m.Memory().Read(ptr, size); ok {
+		c.sink.Write(0, "log", string(msg))
+	}
+}
+
+func (c *WasmController) httpRequest(ctx context.Context, m api.Module, ptr, size uint32) uint64 {
+	var resp WasmHTTPResponse
+	if input, ok := m.Memory().Read(ptr, size); ok {
+		resp = c.fetch(ctx, input)
+	} else {
+		resp.Error = WasmMemoryErr.Error()
+	}
+	output, _ := json.Marshal(resp)
+	out, err := write(ctx, m, output)
+	if err != nil {
+		return 0
+	}
+	return uint64(out)<<32 | uint64(len(output))
+}
+
+// fetch sends the WasmHTTPRequest in json only if its host is listed in the AllowedHosts.
+func (c *WasmController) fetch(ctx context.Context, input []byte) (resp WasmHTTPResponse) {
+	var req WasmHTTPRequest
+	if err := json.Unmarshal(input, &req); err != nil {
+		resp.Error = err.Error()
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		resp.Error = err.Error()
+		return
+	}
+	if !c.allowed(u.Host) {
+		resp.Error = fmt.Sprintf("%s: %s", WasmEgressDeniedErr, u.Host)
+		return
+	}
+	if req.Method == "" {
+		req.Method = http.MethodGet
+	}
+	r, err := http.NewRequestWithContext(ctx, req.Method, req.URL, strings.NewReader(req.Body))
+	if err != nil {
+		resp.Error = err.Error()
+		return
+	}
+	for k, v := range req.Headers {
+		r.Header.Set(k, v)
+	}
+	res, err := c.client.Do(r)
+	if err != nil {
+		resp.Error = err.Error()
+		return
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		resp.Error = err.Error()
+		return
+	}
+	resp.StatusCode, resp.Body, resp.Headers = res.StatusCode, string(body), make(map[string]string, len(res.Header))
+	for k := range res.Header {
+		resp.Headers[k] = res.Header.Get(k)
+	}
+	return
+}
+
+// redirect follows the redirects only to the AllowedHosts, otherwise an allowed host could forward the module anywhere.
+func (c *WasmController) redirect(req *http.Request, via []*http.Request) error {
+	if !c.allowed(req.URL.Host) {
+		return fmt.Errorf("%w: %s", WasmEgressDeniedErr, req.URL.Host)
+	}
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return nil
+}
+
+func (c *WasmController) allowed(host string) bool {
+	for _, h := range c.param.Wasm.AllowedHosts {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// write copies the data into the memory allocated by the exported alloc of the module.
+func write(ctx context.Context, m api.Module, data []byte) (uint32, error) {
+	alloc := m.ExportedFunction("alloc")
+	if alloc == nil {
+		return 0, fmt.Errorf("fail to call alloc: %w", WasmExportNotFoundErr)
+	}
+	ret, err := alloc.Call(ctx, uint64(len(data)))
+	if err != nil {
+		return 0, err
+	}
+	if !m.Memory().Write(uint32(ret[0]), data) {
+		return 0, WasmMemoryErr
+	}
+	return uint32(ret[0]), nil
+}
+
+func read(m api.Module, packed uint64) ([]byte, error) {
+	data, ok := m.Memory().Read(uint32(packed>>32), uint32(packed))
+	if !ok {
+		return nil, WasmMemoryErr
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// sinkWriter writes the stdout and stderr of wasm modules to the LogSink line by line.
+type sinkWriter struct {
+	sink   *LogSink
+	stream string
+}
+
+func (w *sinkWriter) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		w.sink.Write(0, w.stream, string(line))
+	}
+	return len(p), nil
+}
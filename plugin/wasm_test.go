@@ -0,0 +1,234 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/types"
+)
+
+const wasmGuestResult = `{"Result":{"ID":"wasm"}}`
+
+// wasmGuest assembles a module with the given initial memory pages, which exports
+//
+//	alloc(len) a bump allocator starting from 1024
+//	find_resource(ptr, len) logs the args and returns wasmGuestResult
+//	sync_resource(ptr, len) loops forever
+//	fetch(ptr, len) passes the args to http_request and returns its response
+func wasmGuest(pages byte) []byte {
+	uleb := func(v uint64) (b []byte) {
+		for {
+			c := byte(v & 0x7f)
+			if v >>= 7; v != 0 {
+				b = append(b, c|0x80)
+				continue
+			}
+			return append(b, c)
+		}
+	}
+	sleb := func(v int64) (b []byte) {
+		for {
+			c := byte(v & 0x7f)
+			v >>= 7
+			if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+				return append(b, c)
+			}
+			b = append(b, c|0x80)
+		}
+	}
+	cat := func(parts ...[]byte) (b []byte) {
+		for _, p := range parts {
+			b = append(b, p...)
+		}
+		return b
+	}
+	vec := func(items ...[]byte) []byte {
+		return cat(uleb(uint64(len(items))), cat(items...))
+	}
+	name := func(s string) []byte {
+		return cat(uleb(uint64(len(s))), []byte(s))
+	}
+	section := func(id byte, items ...[]byte) []byte {
+		content := vec(items...)
+		return cat([]byte{id}, uleb(uint64(len(content))), content)
+	}
+	body := func(code ...byte) []byte {
+		return cat(uleb(uint64(len(code)+1)), []byte{0x00}, code)
+	}
+	result := cat([]byte{0x42}, sleb(16<<32|int64(len(wasmGuestResult))))
+
+	return cat(
+		[]byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00},
+		section(1,
+			[]byte{0x60, 0x02, 0x7f, 0x7f, 0x00},       // (i32, i32) -> ()
+			[]byte{0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e}, // (i32, i32) -> i64
+			[]byte{0x60, 0x01, 0x7f, 0x01, 0x7f},       // (i32) -> i32
+		),
+		section(2,
+			cat(name(WasmHostModule), name("log"), []byte{0x00, 0x00}),
+			cat(name(WasmHostModule), name("http_request"), []byte{0x00, 0x01}),
+		),
+		section(3, []byte{0x02}, []byte{0x01}, []byte{0x01}, []byte{0x01}),
+		section(5, []byte{0x00, pages}),
+		section(6, cat([]byte{0x7f, 0x01, 0x41}, sleb(1024), []byte{0x0b})),
+		section(7,
+			cat(name("memory"), []byte{0x02, 0x00}),
+			cat(name("alloc"), []byte{0x00, 0x02}),
+			cat(name("find_resource"), []byte{0x00, 0x03}),
+			cat(name("sync_resource"), []byte{0x00, 0x04}),
+			cat(name("fetch"), []byte{0x00, 0x05}),
+		),
+		section(10,
+			body(0x23, 0x00, 0x23, 0x00, 0x20, 0x00, 0x6a, 0x24, 0x00, 0x0b),
+			body(cat([]byte{0x20, 0x00, 0x20, 0x01, 0x10, 0x00}, result, []byte{0x0b})...),
+			body(0x03, 0x40, 0x0c, 0x00, 0x0b, 0x00, 0x0b),
+			body(0x20, 0x00, 0x20, 0x01, 0x10, 0x01, 0x0b),
+		),
+		section(11, cat([]byte{0x00, 0x41, 0x10, 0x0b}, name(wasmGuestResult))),
+	)
+}
+
+var _ = Describe("WasmController", func() {
+	var dir string
+	var param types.CmdParam
+	var controller *WasmController
+	var pages byte
+	var err error
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "wasm")
+		pages = 1
+		param = types.CmdParam{Name: "wasm", Path: filepath.Join(dir, "guest.wasm"), Kind: types.KindWasm}
+	})
+
+	AfterEach(func() {
+		if controller != nil {
+			controller.Close()
+		}
+		os.RemoveAll(dir)
+	})
+
+	JustBeforeEach(func() {
+		Expect(ioutil.WriteFile(param.Path, wasmGuest(pages), 0644)).NotTo(HaveOccurred())
+		controller, err = NewWasmController(param, nil)
+	})
+
+	It("call the exported function with json args and log by the host function", func() {
+		Expect(err).NotTo(HaveOccurred())
+		res, err := controller.FindResource(types.ResourcePool{ID: "pool1"}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res.ID).To(Equal("wasm"))
+
+		lines := controller.sink.Lines()
+		Expect(lines).To(HaveLen(1))
+		Expect(lines[0].Stream).To(Equal("log"))
+		Expect(lines[0].Text).To(ContainSubstring(`"ID":"pool1"`))
+	})
+
+	It("get ExternalListNotSupportedErr without list_external", func() {
+		_, err := controller.ListExternal(types.ResourcePool{ID: "pool1"}, nil)
+		Expect(errors.Is(err, types.ExternalListNotSupportedErr)).To(BeTrue())
+	})
+
+	It("limit http requests by the default timeout", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(controller.client.Timeout).To(Equal(DefaultWasmTimeout))
+	})
+
+	Context("with time limit", func() {
+		BeforeEach(func() {
+			param.Wasm.Timeout = 100 * time.Millisecond
+		})
+		It("stop the endless call", func() {
+			_, err := controller.SyncResource(types.Resource{ID: "a"}, nil)
+			Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+		})
+	})
+
+	Context("with memory limit", func() {
+		BeforeEach(func() {
+			pages = 2
+			param.Wasm.MemoryLimit = wasmPageSize
+		})
+		It("refuse the module requiring more memory", func() {
+			if err == nil {
+				_, err = controller.FindResource(types.ResourcePool{ID: "pool1"}, nil)
+			}
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("http egress", func() {
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("pong"))
+			}))
+			u, _ := url.Parse(server.URL)
+			param.Wasm.AllowedHosts = []string{u.Host}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		fetch := func(target string) (resp WasmHTTPResponse) {
+			input, _ := json.Marshal(WasmHTTPRequest{URL: target})
+			output, err := controller.call("fetch", input)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(json.Unmarshal(output, &resp)).NotTo(HaveOccurred())
+			return
+		}
+
+		It("allow listed hosts", func() {
+			resp := fetch(server.URL)
+			Expect(resp.Error).To(BeEmpty())
+			Expect(resp.StatusCode).To(Equal(200))
+			Expect(resp.Body).To(Equal("pong"))
+		})
+
+		It("deny other hosts", func() {
+			resp := fetch("http://example.com")
+			Expect(resp.Error).To(ContainSubstring(WasmEgressDeniedErr.Error()))
+		})
+
+		It("deny redirects to other hosts", func() {
+			redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, server.URL, http.StatusFound)
+			}))
+			defer redirector.Close()
+			u, _ := url.Parse(redirector.URL)
+			controller.param.Wasm.AllowedHosts = []string{u.Host}
+
+			resp := fetch(redirector.URL)
+			Expect(resp.Error).To(ContainSubstring(WasmEgressDeniedErr.Error()))
+			Expect(resp.Body).To(BeEmpty())
+		})
+	})
+
+	Describe("Launchpad", func() {
+		It("serve the module as a plugin", func() {
+			launchpad := NewLaunchpad()
+			defer launchpad.Close()
+			Expect(launchpad.SetLaunchers(map[string]types.CmdParam{"wasm": param})).NotTo(HaveOccurred())
+
+			c, err := launchpad.GetController("wasm")
+			Expect(err).NotTo(HaveOccurred())
+			res, err := c.FindResource(types.ResourcePool{ID: "pool1"}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.ID).To(Equal("wasm"))
+			Expect(launchpad.Status()[0].Checksum).NotTo(BeEmpty())
+		})
+	})
+})
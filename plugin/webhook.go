@@ -0,0 +1,165 @@
+package plugin
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rueian/godemand/types"
+)
+
+const (
+	DefaultWebhookTimeout       = 10 * time.Second
+	DefaultWebhookRetryInterval = 100 * time.Millisecond
+
+	WebhookTimestampHeader = "X-Godemand-Timestamp"
+	WebhookSignatureHeader = "X-Godemand-Signature"
+)
+
+var (
+	WebhookURLErr       = errors.New("invalid webhook url")
+	WebhookSignatureErr = errors.New("webhook signature mismatch")
+)
+
+// WebhookError is returned when the webhook responds with a non 2xx status.
+type WebhookError struct {
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *WebhookError) Error() string {
+	return fmt.Sprintf("webhook %s responds %d: %s", e.URL, e.StatusCode, e.Body)
+}
+
+// WebhookController implements types.Controller by posting FindResourceArgs, SyncResourceArgs and ListExternalArgs
+// as json to the configured url, and decoding the response body as the result.
+// SyncResource and ListExternal are retried on network errors and 5xx or 429 responses, while FindResource is never retried,
+// since the receiver may have created a resource before the response is lost.
+type WebhookController struct {
+	config types.WebhookConfig
+	client *http.Client
+}
+
+func NewWebhookController(config types.WebhookConfig) (*WebhookController, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("fail to use webhook %q: %w", config.URL, WebhookURLErr)
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultWebhookTimeout
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = DefaultWebhookRetryInterval
+	}
+	return &WebhookController{config: config, client: &http.Client{Timeout: config.Timeout}}, nil
+}
+
+func (c *WebhookController) FindResource(pool types.ResourcePool, params map[string]interface{}) (res types.Resource, err error) {
+	err = c.post("FindResource", &FindResourceArgs{Pool: pool, Params: params}, &res)
+	return
+}
+
+func (c *WebhookController) SyncResource(resource types.Resource, params map[string]interface{}) (res types.Resource, err error) {
+	err = c.post("SyncResource", &SyncResourceArgs{Resource: resource, Params: params}, &res)
+	return
+}
+
+func (c *WebhookController) ListExternal(pool types.ResourcePool, params map[string]interface{}) (res []types.Resource, err error) {
+	err = c.post("ListExternal", &ListExternalArgs{Pool: pool, Params: params}, &res)
+	var werr *WebhookError
+	if errors.As(err, &werr) && (werr.StatusCode == http.StatusNotFound || werr.StatusCode == http.StatusNotImplemented) {
+		err = fmt.Errorf("fail to list external resources: %w", types.ExternalListNotSupportedErr)
+	}
+	return
+}
+
+func (c *WebhookController) post(method string, args, reply interface{}) error {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	retries := c.config.Retries
+	if method == "FindResource" {
+		retries = 0
+	}
+	for attempt := 0; ; attempt++ {
+		retry, err := c.do(method, body, reply)
+		if err == nil || !retry || attempt >= retries {
+			return err
+		}
+		time.Sleep(c.config.RetryInterval << uint(attempt))
+	}
+}
+
+func (c *WebhookController) do(method string, body []byte, reply interface{}) (retry bool, err error) {
+	target := strings.TrimSuffix(c.config.URL, "/") + "/" + method
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range c.config.Headers {
+		req.Header.Set(k, v)
+	}
+	if c.config.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(WebhookTimestampHeader, timestamp)
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(c.config.Secret, timestamp, body))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	out, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return true, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests,
+			&WebhookError{URL: target, StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(out))}
+	}
+	return false, json.Unmarshal(out, reply)
+}
+
+// SignWebhook returns the signature of the body posted at the unix timestamp,
+// which is the hex encoded HMAC-SHA256 of the timestamp, a dot and the body, prefixed by "sha256=".
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook is used by the receiver of a webhook to check the signature of the request,
+// and the request is also rejected if it is signed longer than maxAge ago. It returns the request body if verified.
+func VerifyWebhook(secret string, r *http.Request, maxAge time.Duration) ([]byte, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	timestamp := r.Header.Get(WebhookTimestampHeader)
+	if maxAge > 0 {
+		sec, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || time.Since(time.Unix(sec, 0)) > maxAge {
+			return nil, fmt.Errorf("fail to verify webhook signed at %q: %w", timestamp, WebhookSignatureErr)
+		}
+	}
+	if !hmac.Equal([]byte(r.Header.Get(WebhookSignatureHeader)), []byte(SignWebhook(secret, timestamp, body))) {
+		return nil, fmt.Errorf("fail to verify webhook: %w", WebhookSignatureErr)
+	}
+	return body, nil
+}
@@ -0,0 +1,194 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/types"
+)
+
+var _ = Describe("WebhookController", func() {
+	var server *httptest.Server
+	var handler http.HandlerFunc
+	var config types.WebhookConfig
+	var controller *WebhookController
+	var attempts int32
+	var err error
+
+	BeforeEach(func() {
+		atomic.StoreInt32(&attempts, 0)
+		handler = func(w http.ResponseWriter, r *http.Request) {}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&attempts, 1)
+			handler(w, r)
+		}))
+		config = types.WebhookConfig{URL: server.URL + "/hooks/", Secret: "secret", Headers: map[string]string{"X-Custom": "1"}}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	JustBeforeEach(func() {
+		controller, err = NewWebhookController(config)
+	})
+
+	Context("with signed request", func() {
+		BeforeEach(func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				body, err := VerifyWebhook("secret", r, time.Minute)
+				if err != nil || r.URL.Path != "/hooks/FindResource" || r.Header.Get("X-Custom") != "1" {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				var args FindResourceArgs
+				json.Unmarshal(body, &args)
+				json.NewEncoder(w).Encode(types.Resource{ID: args.Pool.ID + "-" + args.Params["a"].(string)})
+			}
+		})
+
+		It("post args and decode the result", func() {
+			Expect(err).NotTo(HaveOccurred())
+			res, err := controller.FindResource(types.ResourcePool{ID: "pool1"}, map[string]interface{}{"a": "b"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.ID).To(Equal("pool1-b"))
+		})
+
+		Context("with wrong secret", func() {
+			BeforeEach(func() {
+				config.Secret = "wrong"
+			})
+			It("rejected by the receiver", func() {
+				_, err := controller.FindResource(types.ResourcePool{ID: "pool1"}, nil)
+				var werr *WebhookError
+				Expect(errors.As(err, &werr)).To(BeTrue())
+				Expect(werr.StatusCode).To(Equal(http.StatusForbidden))
+				Expect(atomic.LoadInt32(&attempts)).To(Equal(int32(1)))
+			})
+		})
+	})
+
+	Context("with server errors", func() {
+		BeforeEach(func() {
+			config.Retries = 2
+			config.RetryInterval = time.Millisecond
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				if atomic.LoadInt32(&attempts) < 3 {
+					http.Error(w, "busy", http.StatusServiceUnavailable)
+					return
+				}
+				json.NewEncoder(w).Encode(types.Resource{ID: "a"})
+			}
+		})
+
+		It("retry", func() {
+			res, err := controller.SyncResource(types.Resource{ID: "a"}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.ID).To(Equal("a"))
+			Expect(atomic.LoadInt32(&attempts)).To(Equal(int32(3)))
+		})
+
+		It("not retry FindResource", func() {
+			_, err := controller.FindResource(types.ResourcePool{ID: "pool1"}, nil)
+			Expect(err).To(MatchError(ContainSubstring("busy")))
+			Expect(atomic.LoadInt32(&attempts)).To(Equal(int32(1)))
+		})
+
+		Context("more than retries", func() {
+			BeforeEach(func() {
+				config.Retries = 1
+			})
+			It("return the last error", func() {
+				_, err := controller.SyncResource(types.Resource{ID: "a"}, nil)
+				Expect(err).To(MatchError(ContainSubstring("busy")))
+				Expect(atomic.LoadInt32(&attempts)).To(Equal(int32(2)))
+			})
+		})
+	})
+
+	Context("with slow server", func() {
+		BeforeEach(func() {
+			config.Timeout = 50 * time.Millisecond
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(200 * time.Millisecond)
+			}
+		})
+		It("timeout", func() {
+			_, err := controller.SyncResource(types.Resource{ID: "a"}, nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("without ListExternal", func() {
+		BeforeEach(func() {
+			handler = http.NotFound
+		})
+		It("get ExternalListNotSupportedErr", func() {
+			_, err := controller.ListExternal(types.ResourcePool{ID: "pool1"}, nil)
+			Expect(errors.Is(err, types.ExternalListNotSupportedErr)).To(BeTrue())
+		})
+	})
+
+	Context("with invalid url", func() {
+		BeforeEach(func() {
+			config.URL = "localhost"
+		})
+		It("get WebhookURLErr", func() {
+			Expect(errors.Is(err, WebhookURLErr)).To(BeTrue())
+		})
+	})
+
+	Describe("VerifyWebhook", func() {
+		It("reject expired signature", func() {
+			timestamp := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.Header.Set(WebhookTimestampHeader, timestamp)
+			req.Header.Set(WebhookSignatureHeader, SignWebhook("secret", timestamp, nil))
+
+			_, err := VerifyWebhook("secret", req, time.Minute)
+			Expect(errors.Is(err, WebhookSignatureErr)).To(BeTrue())
+		})
+	})
+
+	Describe("Launchpad", func() {
+		var launchpad *Launchpad
+
+		BeforeEach(func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(types.Resource{ID: "a"})
+			}
+			launchpad = NewLaunchpad()
+		})
+
+		AfterEach(func() {
+			launchpad.Close()
+		})
+
+		It("serve the webhook as a plugin", func() {
+			Expect(launchpad.SetLaunchers(map[string]types.CmdParam{
+				"hook": {Name: "hook", Kind: types.KindWebhook, Webhook: config},
+			})).NotTo(HaveOccurred())
+
+			c, err := launchpad.GetController("hook")
+			Expect(err).NotTo(HaveOccurred())
+			res, err := c.FindResource(types.ResourcePool{ID: "pool1"}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.ID).To(Equal("a"))
+			Expect(launchpad.Status()[0].Healthy).To(BeTrue())
+		})
+
+		It("fail with unknown kind", func() {
+			err := launchpad.SetLaunchers(map[string]types.CmdParam{
+				"hook": {Name: "hook", Kind: "random"},
+			})
+			Expect(err).To(MatchError(ContainSubstring(UnknownKindErr.Error())))
+		})
+	})
+})
@@ -0,0 +1,178 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rueian/godemand/config"
+	"github.com/rueian/godemand/metrics"
+	"github.com/rueian/godemand/middleware"
+	"github.com/rueian/godemand/plugin"
+	"github.com/rueian/godemand/types"
+)
+
+// OrphanReconciler periodically diffs the resources reported by the controller's ListExternal
+// against the resources remembered by godemand, and handles the unknown ones by the pool's orphan policy.
+type OrphanReconciler struct {
+	Pool      types.ResourceDAO
+	Locker    types.Locker
+	Launchpad types.Launchpad
+	Config    *config.Config
+	// Chains decorates the controllers by the middlewares of pools, nil means no middleware.
+	Chains *middleware.Chains
+
+	mu sync.Mutex
+	// reported are the ids of orphans already reported by orphan_found events of each pool,
+	// so that an ignored orphan is only reported once while it stays unknown.
+	reported map[string]map[string]bool
+}
+
+func (r *OrphanReconciler) Run(ctx context.Context, period time.Duration) error {
+	for {
+		for id := range r.Config.Pools {
+			if _, cause, err := r.reconcile(id); err != nil {
+				metrics.RecordSyncError(id, cause)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(period):
+		}
+	}
+}
+
+func (r *OrphanReconciler) Reconcile(poolID string) (orphans []types.Resource, err error) {
+	orphans, _, err = r.reconcile(poolID)
+	return
+}
+
+// reconcile handles the orphans of the pool, and returns the cause if it fails.
+func (r *OrphanReconciler) reconcile(poolID string) (orphans []types.Resource, cause string, err error) {
+	poolConfig, err := r.Config.GetPool(poolID)
+	if err != nil {
+		return nil, metrics.CauseConfigMissing, err
+	}
+
+	policy := poolConfig.Orphan
+	switch policy {
+	case "":
+		policy = config.OrphanIgnore
+	case config.OrphanIgnore, config.OrphanAdopt, config.OrphanTeardown:
+	default:
+		return nil, metrics.CauseConfigInvalid, fmt.Errorf("unknown orphan policy %q of pool %q", policy, poolID)
+	}
+
+	controller, err := r.Launchpad.GetController(poolConfig.Plugin)
+	if err != nil {
+		return nil, metrics.CauseControllerMissing, err
+	}
+
+	lister, ok := controller.(types.ExternalLister)
+	if !ok {
+		return nil, "", nil
+	}
+	lister = r.Chains.Wrap(poolID, poolConfig.Middleware, controller).(types.ExternalLister)
+
+	// list without the pool lock, which would block RequestResource of the pool during the whole list call.
+	pool, err := r.Pool.GetResources(poolID)
+	if err != nil {
+		return nil, metrics.CauseDAOError, err
+	}
+	external, err := lister.ListExternal(pool, poolConfig.Params)
+	if errors.Is(err, types.ExternalListNotSupportedErr) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, metrics.CausePluginError, err
+	}
+
+	// then diff with the pool read again under the pool lock, so that resources created by RequestResource
+	// during the list are not treated as orphans.
+	lockID, err := r.Locker.AcquireLock(poolID)
+	if err != nil {
+		if errors.Is(err, plugin.AcquireLaterErr) {
+			return nil, metrics.CauseLockBusy, err
+		}
+		return nil, metrics.CauseLockError, err
+	}
+	defer r.Locker.ReleaseLock(poolID, lockID)
+
+	if pool, err = r.Pool.GetResources(poolID); err != nil {
+		return nil, metrics.CauseDAOError, err
+	}
+
+	r.mu.Lock()
+	reported := r.reported[poolID]
+	r.mu.Unlock()
+	found := make(map[string]bool, len(reported))
+	defer func() {
+		// orphans not listed this time are forgotten, unless the reconcile is interrupted before seeing all of them.
+		if err != nil {
+			for id := range reported {
+				found[id] = true
+			}
+		}
+		r.mu.Lock()
+		if r.reported == nil {
+			r.reported = make(map[string]map[string]bool)
+		}
+		r.reported[poolID] = found
+		r.mu.Unlock()
+	}()
+
+	for _, res := range external {
+		if res.ID == "" {
+			continue
+		}
+		if _, ok := pool.Resources[res.ID]; ok {
+			continue
+		}
+
+		res.PoolID = pool.ID
+		res.Clients = nil
+		if res.CreatedAt.IsZero() {
+			res.CreatedAt = time.Now()
+		}
+		if res.StateChange.IsZero() {
+			res.StateChange = time.Now()
+		}
+
+		if policy == config.OrphanTeardown && res.State != types.ResourceDeleting {
+			res.State = types.ResourceDeleting
+			res.StateChange = time.Now()
+		}
+
+		if policy != config.OrphanIgnore {
+			if res, err = r.Pool.SaveResource(res); err != nil {
+				return orphans, metrics.CauseDAOError, err
+			}
+		}
+
+		if reported[res.ID] {
+			found[res.ID] = true
+			orphans = append(orphans, res)
+			continue
+		}
+		if err = r.Pool.AppendEvent(types.ResourceEvent{
+			ResourcePoolID: pool.ID,
+			ResourceID:     res.ID,
+			Timestamp:      time.Now(),
+			Meta: map[string]interface{}{
+				"type":   "orphan_found",
+				"policy": policy,
+				"state":  res.State,
+			},
+		}); err != nil {
+			return orphans, metrics.CauseDAOError, err
+		}
+		found[res.ID] = true
+		orphans = append(orphans, res)
+	}
+
+	return orphans, "", nil
+}
@@ -0,0 +1,262 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/config"
+	"github.com/rueian/godemand/metrics"
+	"github.com/rueian/godemand/plugin"
+	"github.com/rueian/godemand/resource"
+	"github.com/rueian/godemand/types"
+	"github.com/rueian/godemand/types/mock"
+)
+
+type listerController struct {
+	*mock.MockController
+	*mock.MockExternalLister
+}
+
+var _ = Describe("OrphanReconciler", func() {
+	var reconciler *OrphanReconciler
+	var launchpad *mock.MockLaunchpad
+	var controller listerController
+	var pool types.ResourceDAO
+	var locker *mock.MockLocker
+	var ctrl *gomock.Controller
+	var cfg *config.Config
+	var known types.Resource
+	var external []types.Resource
+	var listErr error
+	var listed *gomock.Call
+	var orphans []types.Resource
+	var cause string
+	var err error
+
+	BeforeEach(func() {
+		ctrl = gomock.NewController(GinkgoT())
+		pool = resource.NewInMemoryResourcePool()
+		locker = mock.NewMockLocker(ctrl)
+		launchpad = mock.NewMockLaunchpad(ctrl)
+		controller = listerController{
+			MockController:     mock.NewMockController(ctrl),
+			MockExternalLister: mock.NewMockExternalLister(ctrl),
+		}
+		cfg = &config.Config{
+			Plugins: map[string]config.PluginConfig{
+				"plugin1": {},
+			},
+			Pools: map[string]config.PoolConfig{
+				"pool1": {
+					Plugin: "plugin1",
+					Params: map[string]interface{}{"a": "a"},
+				},
+			},
+		}
+		known = types.Resource{ID: "known", PoolID: "pool1", State: types.ResourceServing}
+		pool.SaveResource(known)
+		external = []types.Resource{
+			{ID: "known", State: types.ResourceServing},
+			{ID: "orphan", State: types.ResourceServing},
+			{ID: ""},
+		}
+		listErr = nil
+	})
+
+	AfterEach(func() {
+		ctrl.Finish()
+	})
+
+	setPolicy := func(policy string) {
+		c := cfg.Pools["pool1"]
+		c.Orphan = policy
+		cfg.Pools["pool1"] = c
+	}
+
+	JustBeforeEach(func() {
+		reconciler = &OrphanReconciler{
+			Pool:      pool,
+			Locker:    locker,
+			Config:    cfg,
+			Launchpad: launchpad,
+		}
+		orphans, cause, err = reconciler.reconcile("pool1")
+	})
+
+	Context("pool lock fail", func() {
+		BeforeEach(func() {
+			launchpad.EXPECT().GetController("plugin1").Return(controller, nil)
+			controller.MockExternalLister.EXPECT().ListExternal(gomock.Any(), gomock.Any()).Return(external, nil)
+			locker.EXPECT().AcquireLock("pool1").Return("", plugin.AcquireLaterErr)
+		})
+		It("get err", func() {
+			Expect(errors.Is(err, plugin.AcquireLaterErr)).To(BeTrue())
+			Expect(cause).To(Equal(metrics.CauseLockBusy))
+		})
+	})
+
+	Context("unknown policy", func() {
+		BeforeEach(func() {
+			setPolicy("random")
+		})
+		It("get err", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(cause).To(Equal(metrics.CauseConfigInvalid))
+		})
+	})
+
+	Context("controller without ListExternal", func() {
+		BeforeEach(func() {
+			launchpad.EXPECT().GetController("plugin1").Return(controller.MockController, nil)
+		})
+		It("skip", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(orphans).To(BeEmpty())
+		})
+	})
+
+	Context("with ListExternal", func() {
+		BeforeEach(func() {
+			launchpad.EXPECT().GetController("plugin1").Return(controller, nil)
+			p, _ := pool.GetResources("pool1")
+			listed = controller.MockExternalLister.EXPECT().ListExternal(p, cfg.Pools["pool1"].Params).DoAndReturn(func(types.ResourcePool, map[string]interface{}) ([]types.Resource, error) {
+				return external, listErr
+			})
+		})
+
+		// the pool lock is only acquired after the list, and not at all if the list fails.
+		locked := func() {
+			locker.EXPECT().AcquireLock("pool1").Return("lockID", nil).After(listed)
+			locker.EXPECT().ReleaseLock("pool1", "lockID").Return(nil)
+		}
+
+		Context("not supported by plugin", func() {
+			BeforeEach(func() {
+				listErr = types.ExternalListNotSupportedErr
+			})
+			It("skip", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(orphans).To(BeEmpty())
+			})
+		})
+
+		Context("err from controller", func() {
+			BeforeEach(func() {
+				listErr = errors.New("any")
+			})
+			It("get err", func() {
+				Expect(err).To(Equal(listErr))
+				Expect(cause).To(Equal(metrics.CausePluginError))
+			})
+		})
+
+		Context("created during the list", func() {
+			BeforeEach(func() {
+				locked()
+				listed.Do(func(types.ResourcePool, map[string]interface{}) {
+					pool.SaveResource(types.Resource{ID: "orphan", PoolID: "pool1", State: types.ResourceBooting})
+				})
+			})
+			It("not treat it as an orphan", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(orphans).To(BeEmpty())
+			})
+		})
+
+		Context("ignore policy", func() {
+			BeforeEach(locked)
+			It("only record orphan_found event", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(orphans).To(HaveLen(1))
+				Expect(orphans[0].ID).To(Equal("orphan"))
+
+				p, _ := pool.GetResources("pool1")
+				Expect(p.Resources).NotTo(HaveKey("orphan"))
+
+				events, err := pool.GetEventsByResource("pool1", "orphan", 1, time.Now())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(events).To(HaveLen(1))
+				Expect(events[0].Meta).To(HaveKeyWithValue("type", "orphan_found"))
+				Expect(events[0].Meta).To(HaveKeyWithValue("policy", config.OrphanIgnore))
+			})
+
+			It("report the orphan only once", func() {
+				launchpad.EXPECT().GetController("plugin1").Return(controller, nil)
+				controller.MockExternalLister.EXPECT().ListExternal(gomock.Any(), gomock.Any()).Return(external, nil)
+				locker.EXPECT().AcquireLock("pool1").Return("lockID", nil)
+				locker.EXPECT().ReleaseLock("pool1", "lockID").Return(nil)
+				orphans, err = reconciler.Reconcile("pool1")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(orphans).To(HaveLen(1))
+
+				events, err := pool.GetEventsByResource("pool1", "orphan", 10, time.Now())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(events).To(HaveLen(1))
+			})
+		})
+
+		Context("adopt policy", func() {
+			BeforeEach(func() {
+				locked()
+				setPolicy(config.OrphanAdopt)
+			})
+			It("save the orphan", func() {
+				Expect(err).NotTo(HaveOccurred())
+				p, _ := pool.GetResources("pool1")
+				Expect(p.Resources).To(HaveKey("orphan"))
+				Expect(p.Resources["orphan"].State).To(Equal(types.ResourceServing))
+				Expect(p.Resources["orphan"].PoolID).To(Equal("pool1"))
+				Expect(p.Resources["orphan"].CreatedAt).NotTo(BeZero())
+				Expect(p.Resources).NotTo(HaveKey(""))
+			})
+		})
+
+		Context("teardown policy", func() {
+			BeforeEach(func() {
+				locked()
+				setPolicy(config.OrphanTeardown)
+			})
+			It("save the orphan as deleting", func() {
+				Expect(err).NotTo(HaveOccurred())
+				p, _ := pool.GetResources("pool1")
+				Expect(p.Resources).To(HaveKey("orphan"))
+				Expect(p.Resources["orphan"].State).To(Equal(types.ResourceDeleting))
+				Expect(p.Resources["known"].State).To(Equal(types.ResourceServing))
+
+				events, err := pool.GetEventsByResource("pool1", "orphan", 1, time.Now())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(events).To(HaveLen(1))
+				Expect(events[0].Meta).To(HaveKeyWithValue("policy", config.OrphanTeardown))
+			})
+		})
+	})
+})
+
+var _ = Describe("ResourceSyncer with OrphanPeriod", func() {
+	It("reconcile orphans of pools periodically", func() {
+		ctrl := gomock.NewController(GinkgoT())
+		defer ctrl.Finish()
+		launchpad := mock.NewMockLaunchpad(ctrl)
+		ctx, cancel := context.WithCancel(context.Background())
+		lister := mock.NewMockExternalLister(ctrl)
+		controller := listerController{MockController: mock.NewMockController(ctrl), MockExternalLister: lister}
+		launchpad.EXPECT().GetController("plugin1").Return(controller, nil)
+		lister.EXPECT().ListExternal(gomock.Any(), gomock.Any()).DoAndReturn(func(types.ResourcePool, map[string]interface{}) ([]types.Resource, error) {
+			cancel()
+			return nil, types.ExternalListNotSupportedErr
+		})
+
+		syncer := &ResourceSyncer{
+			Pool:         resource.NewInMemoryResourcePool(),
+			Locker:       mock.NewMockLocker(ctrl),
+			Launchpad:    launchpad,
+			Config:       &config.Config{Pools: map[string]config.PoolConfig{"pool1": {Plugin: "plugin1"}}},
+			OrphanPeriod: time.Hour,
+		}
+		Expect(syncer.Run(ctx, 1)).To(Equal(context.Canceled))
+	})
+})
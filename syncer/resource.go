@@ -2,11 +2,17 @@ package syncer
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"time"
 
 	"github.com/rueian/godemand/config"
 	"github.com/rueian/godemand/metrics"
+	"github.com/rueian/godemand/middleware"
+	"github.com/rueian/godemand/plugin"
+	"github.com/rueian/godemand/tracing"
 	"github.com/rueian/godemand/types"
+	"go.opencensus.io/trace"
 )
 
 type ResourceSyncer struct {
@@ -14,83 +20,34 @@ type ResourceSyncer struct {
 	Locker    types.Locker
 	Launchpad types.Launchpad
 	Config    *config.Config
-
-	queue chan types.Resource
+	// Chains decorates the controllers by the middlewares of pools, nil means no middleware.
+	Chains *middleware.Chains
+	// OrphanPeriod is how often the pools are reconciled by an OrphanReconciler, zero means never.
+	OrphanPeriod time.Duration
+
+	queue  chan job
+	mu     sync.Mutex
+	queued map[string]int64 // number of resources of each pool in the queue
 }
 
 func (s *ResourceSyncer) Run(ctx context.Context, workers int) error {
-	s.queue = make(chan types.Resource, workers)
+	s.queue = make(chan job, workers)
+	s.queued = make(map[string]int64)
+
+	if s.OrphanPeriod > 0 {
+		reconciler := &OrphanReconciler{Pool: s.Pool, Locker: s.Locker, Launchpad: s.Launchpad, Config: s.Config, Chains: s.Chains}
+		go reconciler.Run(ctx, s.OrphanPeriod)
+	}
 
 	for i := 0; i < workers; i++ {
 		go func() {
-			for res := range s.queue {
-				config, err := s.Config.GetPool(res.PoolID)
-				if err != nil {
-					// TODO logging
-					continue
-				}
-
-				controller, err := s.Launchpad.GetController(config.Plugin)
-				if err != nil {
-					// TODO logging
-					continue
-				}
-
-				lockID, err := s.Locker.AcquireLock(res.ID)
-				if err != nil {
-					// TODO logging
-					continue
-				}
-
-				var ret types.Resource
-				for {
-					ret, err = controller.SyncResource(res, types.Merge(config.Params, res.Config))
-					if err != nil {
-						break
-					}
-					if ret.State != res.State && ret.StateChange == res.StateChange {
-						ret.StateChange = time.Now()
-					}
-					if _, err = s.Pool.SaveResource(ret); err != nil {
-						break
-					}
-					if ret.State == types.ResourceDeleted {
-						if err = s.Pool.DeleteResource(ret); err == nil {
-							err = s.Pool.AppendEvent(types.ResourceEvent{
-								ResourcePoolID: ret.PoolID,
-								ResourceID:     ret.ID,
-								Timestamp:      time.Now(),
-								Meta: map[string]interface{}{
-									"type": "deleted",
-								},
-							})
-						}
-						break
-					}
-					if ret.State == res.State {
-						break
-					}
-					if err = s.Pool.AppendEvent(types.ResourceEvent{
-						ResourcePoolID: ret.PoolID,
-						ResourceID:     ret.ID,
-						Timestamp:      time.Now(),
-						Meta: map[string]interface{}{
-							"type":  "state",
-							"prev":  res.State,
-							"next":  ret.State,
-							"since": res.StateChange,
-							"taken": int(time.Since(res.StateChange).Seconds()),
-						},
-					}); err != nil {
-						break
-					}
-					res = ret
-				}
-				s.Locker.ReleaseLock(res.ID, lockID)
-				if err != nil {
+			for j := range s.queue {
+				s.track(j.res.PoolID, -1)
+				if cause, err := s.sync(j.res); err != nil {
+					metrics.RecordSyncError(j.res.PoolID, cause)
 					// TODO logging
-					continue
 				}
+				j.sweep.Done()
 			}
 		}()
 	}
@@ -108,41 +65,32 @@ func (s *ResourceSyncer) Run(ctx context.Context, workers int) error {
 		pools := s.Config.Pools
 
 		for id := range pools {
+			sweep := time.Now()
 			pool, err := s.Pool.GetResources(id)
 			if err != nil {
+				metrics.RecordSyncError(id, metrics.CauseDAOError)
 				// TODO logging
 				continue
 			}
 
+			wg := &sync.WaitGroup{}
+			wg.Add(len(pool.Resources))
 			for _, res := range pool.Resources {
-				s.queue <- res
-			}
-
-			// record metrics
-			sc := stateCounter()
-			clients := 0
-			for _, res := range pool.Resources {
-				sc[res.State.String()]++
-				metrics.RecordResourceLife(res.PoolID, res.State.String(), res.ID, time.Since(res.StateChange))
-				for _, c := range res.Clients {
-					if time.Since(c.Heartbeat) < 1*time.Minute {
-						clients++
-						metrics.RecordClientLife(res.PoolID, c.ID, c.Heartbeat.Sub(c.CreatedAt))
-						if rt, ok := c.Meta["requestAt"]; ok {
-							rt := toTime(rt)
-							ut := time.Now()
-							if st, ok := c.Meta["servedAt"]; ok {
-								ut = toTime(st)
-							}
-							metrics.RecordClientWait(res.PoolID, c.ID, ut.Sub(rt))
-						}
-					}
+				last := res.LastSynced
+				if last.IsZero() {
+					last = res.CreatedAt
 				}
+				metrics.RecordSyncLag(id, time.Since(last))
+
+				s.track(id, 1)
+				s.queue <- job{res: res, sweep: wg}
 			}
-			for state, count := range sc {
-				metrics.RecordResourceCount(id, state, count)
-			}
-			metrics.RecordClientCount(id, int64(clients))
+			go func(id string) {
+				wg.Wait()
+				metrics.RecordSyncSweep(id, time.Since(sweep))
+			}(id)
+
+			metrics.RecordPool(pool)
 		}
 
 		if time.Since(begin) < time.Second {
@@ -151,22 +99,116 @@ func (s *ResourceSyncer) Run(ctx context.Context, workers int) error {
 	}
 }
 
-func stateCounter() map[string]int64 {
-	counter := make(map[string]int64)
-	for _, s := range types.ResourceStates {
-		counter[s.String()] = 0
-	}
-	return counter
+// job is a resource in the sync queue, which is done for the sweep of its pool once synced.
+type job struct {
+	res   types.Resource
+	sweep *sync.WaitGroup
+}
+
+// track counts the resources of the pool in the queue.
+func (s *ResourceSyncer) track(pool string, delta int64) {
+	s.mu.Lock()
+	s.queued[pool] += delta
+	queued := s.queued[pool]
+	s.mu.Unlock()
+	metrics.RecordSyncQueue(pool, queued)
 }
 
-func toTime(t interface{}) time.Time {
-	switch v := t.(type) {
-	case time.Time:
-		return v
-	case string:
-		ts, _ := time.Parse(time.RFC3339, v)
-		return ts
+// sync calls SyncResource until the state of the resource is settled, and returns the cause if it fails.
+func (s *ResourceSyncer) sync(res types.Resource) (cause string, err error) {
+	config, err := s.Config.GetPool(res.PoolID)
+	if err != nil {
+		return metrics.CauseConfigMissing, err
 	}
 
-	return time.Time{}
+	controller, err := s.Launchpad.GetController(config.Plugin)
+	if err != nil {
+		return metrics.CauseControllerMissing, err
+	}
+	controller = s.Chains.Wrap(res.PoolID, config.Middleware, controller)
+
+	ctx, span := trace.StartSpan(context.Background(), tracing.SpanSyncResource)
+	span.AddAttributes(trace.StringAttribute(tracing.AttrPool, res.PoolID), trace.StringAttribute(tracing.AttrResource, res.ID))
+	defer func() { tracing.End(span, err) }()
+	locker, dao := tracing.Locker(ctx, s.Locker), tracing.DAO(ctx, s.Pool)
+
+	lockID, err := locker.AcquireLock(res.ID)
+	if err != nil {
+		if errors.Is(err, plugin.AcquireLaterErr) {
+			return metrics.CauseLockBusy, err
+		}
+		return metrics.CauseLockError, err
+	}
+	defer locker.ReleaseLock(res.ID, lockID)
+
+	for {
+		ret, err := types.SyncResource(ctx, controller, res, types.Merge(config.Params, res.Config))
+		if err != nil {
+			return metrics.CausePluginError, err
+		}
+		if err = types.ValidateSynced(res, ret); err != nil {
+			var rerr *types.ResponseError
+			if errors.As(err, &rerr) {
+				if eerr := dao.AppendEvent(types.ResourceEvent{
+					ResourcePoolID: res.PoolID,
+					ResourceID:     res.ID,
+					Timestamp:      time.Now(),
+					Meta: map[string]interface{}{
+						"type":   "controller_error",
+						"plugin": config.Plugin,
+						"method": rerr.Method,
+						"field":  rerr.Field,
+						"reason": rerr.Reason,
+					},
+				}); eerr != nil {
+					return metrics.CauseDAOError, eerr
+				}
+			}
+			return metrics.CauseInvalidResponse, err
+		}
+		ret.LastSynced = time.Now()
+		if ret.State != res.State {
+			metrics.RecordSyncTransition(res.PoolID, ret.State.String())
+			if ret.StateChange == res.StateChange {
+				ret.StateChange = time.Now()
+			}
+		}
+		if _, err = dao.SaveResource(ret); err != nil {
+			return metrics.CauseDAOError, err
+		}
+		if ret.State == types.ResourceDeleted {
+			if err = dao.DeleteResource(ret); err == nil {
+				err = dao.AppendEvent(types.ResourceEvent{
+					ResourcePoolID: ret.PoolID,
+					ResourceID:     ret.ID,
+					Timestamp:      time.Now(),
+					Meta: map[string]interface{}{
+						"type": "deleted",
+					},
+				})
+			}
+			if err != nil {
+				return metrics.CauseDAOError, err
+			}
+			return "", nil
+		}
+		if ret.State == res.State {
+			return "", nil
+		}
+		if err = dao.AppendEvent(types.ResourceEvent{
+			ResourcePoolID: ret.PoolID,
+			ResourceID:     ret.ID,
+			Timestamp:      time.Now(),
+			Meta: map[string]interface{}{
+				"type":  "state",
+				"prev":  res.State,
+				"next":  ret.State,
+				"since": res.StateChange,
+				"taken": int(time.Since(res.StateChange).Seconds()),
+			},
+		}); err != nil {
+			return metrics.CauseDAOError, err
+		}
+		res = ret
+	}
 }
@@ -11,10 +11,13 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/rueian/godemand/config"
+	"github.com/rueian/godemand/metrics"
 	"github.com/rueian/godemand/plugin"
 	"github.com/rueian/godemand/resource"
 	"github.com/rueian/godemand/types"
 	"github.com/rueian/godemand/types/mock"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
 )
 
 var _ = Describe("Syncer", func() {
@@ -218,6 +221,138 @@ var _ = Describe("Syncer", func() {
 			})
 		})
 	})
+
+	Describe("metrics", func() {
+		views := []*view.View{metrics.SyncErrorView, metrics.SyncTransitionView, metrics.SyncLagView, metrics.SyncSweepView, metrics.SyncQueueView}
+
+		BeforeEach(func() {
+			ctx, cancel = context.WithCancel(context.Background())
+			Expect(view.Register(views...)).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			view.Unregister(views...)
+		})
+
+		JustBeforeEach(func() {
+			err = syncer.Run(ctx, 1)
+		})
+
+		count := func(v *view.View, key tag.Key, value string) func() int64 {
+			return func() int64 {
+				rows, err := view.RetrieveData(v.Name)
+				Expect(err).NotTo(HaveOccurred())
+				for _, row := range rows {
+					for _, t := range row.Tags {
+						if t.Key == key && t.Value == value {
+							switch data := row.Data.(type) {
+							case *view.CountData:
+								return data.Value
+							case *view.DistributionData:
+								return data.Count
+							}
+						}
+					}
+				}
+				return 0
+			}
+		}
+
+		Context("state changed", func() {
+			BeforeEach(func() {
+				launchpad.EXPECT().GetController("plugin1").Return(controller, nil)
+				locker.EXPECT().AcquireLock(res.ID).Return("lockID", nil)
+				locker.EXPECT().ReleaseLock(res.ID, "lockID").Return(nil)
+				controller.EXPECT().SyncResource(gomock.Any(), gomock.Any()).DoAndReturn(func(res types.Resource, params map[string]interface{}) (types.Resource, error) {
+					if res.State == types.ResourcePending {
+						res.State = types.ResourceServing
+					} else {
+						cancel()
+					}
+					return res, nil
+				}).Times(2)
+			})
+			It("record the transition, the lag and the sweep", func() {
+				Expect(err).To(Equal(context.Canceled))
+				Eventually(count(metrics.SyncTransitionView, metrics.KeyState, "serving")).Should(Equal(int64(1)))
+				Expect(count(metrics.SyncLagView, metrics.KeyPool, "pool1")()).To(Equal(int64(1)))
+				Eventually(count(metrics.SyncSweepView, metrics.KeyPool, "pool1")).Should(Equal(int64(1)))
+				saved, _ := pool.GetResource("pool1", res.ID)
+				Expect(saved.LastSynced).NotTo(BeZero())
+			})
+		})
+
+		Context("slow sync", func() {
+			BeforeEach(func() {
+				launchpad.EXPECT().GetController("plugin1").Return(controller, nil)
+				locker.EXPECT().AcquireLock(res.ID).Return("lockID", nil)
+				locker.EXPECT().ReleaseLock(res.ID, "lockID").Return(nil)
+				controller.EXPECT().SyncResource(gomock.Any(), gomock.Any()).DoAndReturn(func(res types.Resource, params map[string]interface{}) (types.Resource, error) {
+					time.Sleep(100 * time.Millisecond)
+					cancel()
+					return res, nil
+				})
+			})
+			It("record the sweep until the sync finished", func() {
+				Eventually(count(metrics.SyncSweepView, metrics.KeyPool, "pool1")).Should(Equal(int64(1)))
+				rows, err := view.RetrieveData(metrics.SyncSweepView.Name)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rows[0].Data.(*view.DistributionData).Min).To(BeNumerically(">=", 100))
+			})
+		})
+
+		Context("invalid response", func() {
+			BeforeEach(func() {
+				launchpad.EXPECT().GetController("plugin1").Return(controller, nil)
+				locker.EXPECT().AcquireLock(res.ID).Return("lockID", nil)
+				locker.EXPECT().ReleaseLock(res.ID, "lockID").Return(nil)
+				controller.EXPECT().SyncResource(gomock.Any(), gomock.Any()).DoAndReturn(func(res types.Resource, params map[string]interface{}) (types.Resource, error) {
+					cancel()
+					return types.Resource{State: types.ResourceServing}, nil
+				})
+			})
+			It("not save the resource and append controller_error events", func() {
+				Eventually(count(metrics.SyncErrorView, metrics.KeyCause, metrics.CauseInvalidResponse)).Should(Equal(int64(1)))
+				saved, _ := pool.GetResource("pool1", res.ID)
+				Expect(saved.State).To(Equal(res.State))
+				events, _ := pool.GetEventsByResource("pool1", res.ID, 10, time.Now())
+				Expect(events).To(HaveLen(1))
+				Expect(events[0].Meta).To(HaveKeyWithValue("type", "controller_error"))
+				Expect(events[0].Meta).To(HaveKeyWithValue("field", "ID"))
+			})
+		})
+
+		for _, c := range []struct {
+			cause string
+			setup func()
+		}{
+			{metrics.CauseControllerMissing, func() {
+				launchpad.EXPECT().GetController("plugin1").Return(nil, plugin.ControllerNotFoundErr).Do(func(string) { cancel() })
+			}},
+			{metrics.CauseLockBusy, func() {
+				launchpad.EXPECT().GetController("plugin1").Return(controller, nil)
+				locker.EXPECT().AcquireLock(res.ID).Return("", plugin.AcquireLaterErr).Do(func(string) { cancel() })
+			}},
+			{metrics.CausePluginError, func() {
+				launchpad.EXPECT().GetController("plugin1").Return(controller, nil)
+				locker.EXPECT().AcquireLock(res.ID).Return("lockID", nil)
+				locker.EXPECT().ReleaseLock(res.ID, "lockID").Return(nil)
+				controller.EXPECT().SyncResource(gomock.Any(), gomock.Any()).DoAndReturn(func(res types.Resource, params map[string]interface{}) (types.Resource, error) {
+					cancel()
+					return res, errors.New("random")
+				})
+			}},
+		} {
+			func(cause string, setup func()) {
+				Context(cause, func() {
+					BeforeEach(setup)
+					It("record the error by cause", func() {
+						Eventually(count(metrics.SyncErrorView, metrics.KeyCause, cause)).Should(Equal(int64(1)))
+					})
+				})
+			}(c.cause, c.setup)
+		}
+	})
 })
 
 func TestSyncer(t *testing.T) {
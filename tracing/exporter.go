@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opencensus.io/trace"
+)
+
+// Record is a finished span written by the Exporter, where the ids are hex encoded.
+type Record struct {
+	TraceID    string
+	SpanID     string
+	ParentID   string `json:",omitempty"`
+	Name       string
+	Kind       int `json:",omitempty"`
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{} `json:",omitempty"`
+	Code       int32                  `json:",omitempty"`
+	Message    string                 `json:",omitempty"`
+}
+
+func record(s *trace.SpanData) Record {
+	r := Record{
+		TraceID:    s.TraceID.String(),
+		SpanID:     s.SpanID.String(),
+		Name:       s.Name,
+		Kind:       s.SpanKind,
+		Start:      s.StartTime,
+		End:        s.EndTime,
+		Attributes: s.Attributes,
+		Code:       s.Code,
+		Message:    s.Message,
+	}
+	if s.ParentSpanID != (trace.SpanID{}) {
+		r.ParentID = s.ParentSpanID.String()
+	}
+	return r
+}
+
+// Exporter writes each finished span to the writer as a line of json.
+type Exporter struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+}
+
+func NewExporter(w io.Writer) *Exporter {
+	return &Exporter{enc: json.NewEncoder(w)}
+}
+
+// NewFileExporter appends the spans to the file, which is closed by the Close.
+func NewFileExporter(path string) (*Exporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Exporter{enc: json.NewEncoder(file), closer: file}, nil
+}
+
+func (e *Exporter) ExportSpan(s *trace.SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.enc.Encode(record(s))
+}
+
+func (e *Exporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closer != nil {
+		return e.closer.Close()
+	}
+	return nil
+}
+
+// ReadFile reads the spans written by the Exporter.
+func ReadFile(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("fail to decode span %d of %s: %w", len(records)+1, path, err)
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+// LogExporter prints each finished span by the logger in a line.
+type LogExporter struct {
+	Logger *log.Logger
+}
+
+func (e *LogExporter) ExportSpan(s *trace.SpanData) {
+	r := record(s)
+	line := fmt.Sprintf("span %s trace=%s span=%s parent=%s duration=%s", r.Name, r.TraceID, r.SpanID, r.ParentID, r.End.Sub(r.Start))
+	if r.Code != trace.StatusCodeOK {
+		line += fmt.Sprintf(" error=%q", r.Message)
+	}
+	keys := make([]string, 0, len(r.Attributes))
+	for k := range r.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var attrs []string
+	for _, k := range keys {
+		attrs = append(attrs, fmt.Sprintf("%s=%v", k, r.Attributes[k]))
+	}
+	if len(attrs) > 0 {
+		line += " " + strings.Join(attrs, " ")
+	}
+	e.Logger.Println(line)
+}
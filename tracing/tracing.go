@@ -0,0 +1,150 @@
+// Package tracing names the trace spans of godemand, and exports them to files or logs.
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/rueian/godemand/types"
+	"go.opencensus.io/trace"
+)
+
+const (
+	SpanHTTPRequestResource = "godemand/http/RequestResource"
+	SpanRequestResource     = "godemand/api/RequestResource"
+	SpanSyncResource        = "godemand/syncer/SyncResource"
+	SpanAcquireLock         = "godemand/lock/AcquireLock"
+	SpanReleaseLock         = "godemand/lock/ReleaseLock"
+	// SpanDAO prefixes the spans of the ResourceDAO methods.
+	SpanDAO = "godemand/dao/"
+	// SpanPlugin prefixes the client spans of the plugin rpc methods.
+	SpanPlugin = "godemand/plugin/"
+	// SpanPluginServe prefixes the server spans of the plugin rpc methods, which are started in the plugin process.
+	SpanPluginServe = "godemand/plugin/serve/"
+
+	AttrPool     = "pool"
+	AttrResource = "resource"
+	AttrClient   = "client"
+	AttrPlugin   = "plugin"
+)
+
+// StartTracing registers the exporters, and samples the fraction of traces which are not sampled by their remote parents.
+func StartTracing(fraction float64, es ...trace.Exporter) {
+	for _, e := range es {
+		trace.RegisterExporter(e)
+	}
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(fraction)})
+}
+
+// End marks the span failed by the err if it is not nil, and ends the span.
+func End(span *trace.Span, err error) {
+	if err != nil {
+		span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
+	}
+	span.End()
+}
+
+// Locker traces the lock calls as children of the span in the ctx.
+func Locker(ctx context.Context, locker types.Locker) types.Locker {
+	return &tracedLocker{ctx: ctx, next: locker}
+}
+
+type tracedLocker struct {
+	ctx  context.Context
+	next types.Locker
+}
+
+func (l *tracedLocker) AcquireLock(key string) (id string, err error) {
+	_, span := trace.StartSpan(l.ctx, SpanAcquireLock)
+	span.AddAttributes(trace.StringAttribute("key", key))
+	id, err = l.next.AcquireLock(key)
+	End(span, err)
+	return
+}
+
+func (l *tracedLocker) ReleaseLock(key, id string) (err error) {
+	_, span := trace.StartSpan(l.ctx, SpanReleaseLock)
+	span.AddAttributes(trace.StringAttribute("key", key))
+	err = l.next.ReleaseLock(key, id)
+	End(span, err)
+	return
+}
+
+// DAO traces the dao calls as children of the span in the ctx.
+func DAO(ctx context.Context, dao types.ResourceDAO) types.ResourceDAO {
+	return &tracedDAO{ctx: ctx, next: dao}
+}
+
+type tracedDAO struct {
+	ctx  context.Context
+	next types.ResourceDAO
+}
+
+func (d *tracedDAO) start(method, pool string) *trace.Span {
+	_, span := trace.StartSpan(d.ctx, SpanDAO+method)
+	span.AddAttributes(trace.StringAttribute(AttrPool, pool))
+	return span
+}
+
+func (d *tracedDAO) GetResource(pool, id string) (res types.Resource, err error) {
+	span := d.start("GetResource", pool)
+	res, err = d.next.GetResource(pool, id)
+	End(span, err)
+	return
+}
+
+func (d *tracedDAO) GetResources(id string) (pool types.ResourcePool, err error) {
+	span := d.start("GetResources", id)
+	pool, err = d.next.GetResources(id)
+	End(span, err)
+	return
+}
+
+func (d *tracedDAO) SaveResource(resource types.Resource) (res types.Resource, err error) {
+	span := d.start("SaveResource", resource.PoolID)
+	res, err = d.next.SaveResource(resource)
+	End(span, err)
+	return
+}
+
+func (d *tracedDAO) DeleteResource(resource types.Resource) (err error) {
+	span := d.start("DeleteResource", resource.PoolID)
+	err = d.next.DeleteResource(resource)
+	End(span, err)
+	return
+}
+
+func (d *tracedDAO) SaveClient(resource types.Resource, client types.Client) (c types.Client, err error) {
+	span := d.start("SaveClient", resource.PoolID)
+	c, err = d.next.SaveClient(resource, client)
+	End(span, err)
+	return
+}
+
+func (d *tracedDAO) DeleteClients(resource types.Resource, clients []types.Client) (err error) {
+	span := d.start("DeleteClients", resource.PoolID)
+	err = d.next.DeleteClients(resource, clients)
+	End(span, err)
+	return
+}
+
+func (d *tracedDAO) AppendEvent(event types.ResourceEvent) (err error) {
+	span := d.start("AppendEvent", event.ResourcePoolID)
+	err = d.next.AppendEvent(event)
+	End(span, err)
+	return
+}
+
+func (d *tracedDAO) GetEventsByPool(id string, limit int, before time.Time) (events []types.ResourceEvent, err error) {
+	span := d.start("GetEventsByPool", id)
+	events, err = d.next.GetEventsByPool(id, limit, before)
+	End(span, err)
+	return
+}
+
+func (d *tracedDAO) GetEventsByResource(poolID, id string, limit int, before time.Time) (events []types.ResourceEvent, err error) {
+	span := d.start("GetEventsByResource", poolID)
+	events, err = d.next.GetEventsByResource(poolID, id, limit, before)
+	End(span, err)
+	return
+}
@@ -0,0 +1,102 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rueian/godemand/resource"
+	"github.com/rueian/godemand/types"
+	"github.com/rueian/godemand/types/mock"
+	"go.opencensus.io/trace"
+)
+
+var _ = Describe("Tracing", func() {
+	var dir string
+	var exporter *Exporter
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "tracing")
+		Expect(err).NotTo(HaveOccurred())
+		exporter, err = NewFileExporter(filepath.Join(dir, "spans.json"))
+		Expect(err).NotTo(HaveOccurred())
+		trace.RegisterExporter(exporter)
+	})
+
+	AfterEach(func() {
+		trace.UnregisterExporter(exporter)
+		exporter.Close()
+		os.RemoveAll(dir)
+	})
+
+	spans := func() []Record {
+		records, err := ReadFile(filepath.Join(dir, "spans.json"))
+		Expect(err).NotTo(HaveOccurred())
+		return records
+	}
+
+	It("write spans to the file", func() {
+		ctx, parent := trace.StartSpan(context.Background(), "parent", trace.WithSampler(trace.AlwaysSample()))
+		_, child := trace.StartSpan(ctx, "child")
+		child.AddAttributes(trace.StringAttribute(AttrPool, "pool1"))
+		End(child, errors.New("boom"))
+		End(parent, nil)
+
+		records := spans()
+		Expect(records).To(HaveLen(2))
+		Expect(records[0].Name).To(Equal("child"))
+		Expect(records[0].ParentID).To(Equal(records[1].SpanID))
+		Expect(records[0].TraceID).To(Equal(records[1].TraceID))
+		Expect(records[0].Attributes).To(HaveKeyWithValue(AttrPool, "pool1"))
+		Expect(records[0].Message).To(Equal("boom"))
+		Expect(records[1].ParentID).To(BeEmpty())
+		Expect(records[1].Code).To(BeZero())
+	})
+
+	It("print spans by the logger", func() {
+		var buf bytes.Buffer
+		e := &LogExporter{Logger: log.New(&buf, "", 0)}
+		trace.RegisterExporter(e)
+		defer trace.UnregisterExporter(e)
+
+		_, span := trace.StartSpan(context.Background(), "logged", trace.WithSampler(trace.AlwaysSample()))
+		span.AddAttributes(trace.StringAttribute(AttrPool, "pool1"))
+		End(span, errors.New("boom"))
+
+		Expect(buf.String()).To(HavePrefix("span logged trace="))
+		Expect(buf.String()).To(ContainSubstring(`error="boom" pool=pool1`))
+	})
+
+	It("trace the dao and the locker calls as children", func() {
+		ctrl := gomock.NewController(GinkgoT())
+		defer ctrl.Finish()
+		locker := mock.NewMockLocker(ctrl)
+		locker.EXPECT().AcquireLock("pool1").Return("", errors.New("locked"))
+
+		ctx, parent := trace.StartSpan(context.Background(), "parent", trace.WithSampler(trace.AlwaysSample()))
+		dao := DAO(ctx, resource.NewInMemoryResourcePool())
+		_, err := dao.SaveResource(types.Resource{ID: "a", PoolID: "pool1"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = Locker(ctx, locker).AcquireLock("pool1")
+		Expect(err).To(HaveOccurred())
+		parent.End()
+
+		records := spans()
+		Expect(records).To(HaveLen(3))
+		Expect(records[0].Name).To(Equal(SpanDAO + "SaveResource"))
+		Expect(records[0].Attributes).To(HaveKeyWithValue(AttrPool, "pool1"))
+		Expect(records[1].Name).To(Equal(SpanAcquireLock))
+		Expect(records[1].Message).To(Equal("locked"))
+		for _, r := range records[:2] {
+			Expect(r.ParentID).To(Equal(records[2].SpanID))
+		}
+	})
+})
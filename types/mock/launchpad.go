@@ -60,6 +60,20 @@ func (mr *MockLaunchpadMockRecorder) GetController(arg0 interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetController", reflect.TypeOf((*MockLaunchpad)(nil).GetController), arg0)
 }
 
+// Restart mocks base method
+func (m *MockLaunchpad) Restart(arg0 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Restart", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Restart indicates an expected call of Restart
+func (mr *MockLaunchpadMockRecorder) Restart(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Restart", reflect.TypeOf((*MockLaunchpad)(nil).Restart), arg0)
+}
+
 // SetLaunchers mocks base method
 func (m *MockLaunchpad) SetLaunchers(arg0 map[string]types.CmdParam) error {
 	m.ctrl.T.Helper()
@@ -73,3 +87,31 @@ func (mr *MockLaunchpadMockRecorder) SetLaunchers(arg0 interface{}) *gomock.Call
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLaunchers", reflect.TypeOf((*MockLaunchpad)(nil).SetLaunchers), arg0)
 }
+
+// Status mocks base method
+func (m *MockLaunchpad) Status() []types.PluginStatus {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Status")
+	ret0, _ := ret[0].([]types.PluginStatus)
+	return ret0
+}
+
+// Status indicates an expected call of Status
+func (mr *MockLaunchpadMockRecorder) Status() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Status", reflect.TypeOf((*MockLaunchpad)(nil).Status))
+}
+
+// Stop mocks base method
+func (m *MockLaunchpad) Stop(arg0 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stop", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Stop indicates an expected call of Stop
+func (mr *MockLaunchpadMockRecorder) Stop(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stop", reflect.TypeOf((*MockLaunchpad)(nil).Stop), arg0)
+}
@@ -0,0 +1,49 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/rueian/godemand/types (interfaces: ExternalLister)
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	gomock "github.com/golang/mock/gomock"
+	types "github.com/rueian/godemand/types"
+	reflect "reflect"
+)
+
+// MockExternalLister is a mock of ExternalLister interface
+type MockExternalLister struct {
+	ctrl     *gomock.Controller
+	recorder *MockExternalListerMockRecorder
+}
+
+// MockExternalListerMockRecorder is the mock recorder for MockExternalLister
+type MockExternalListerMockRecorder struct {
+	mock *MockExternalLister
+}
+
+// NewMockExternalLister creates a new mock instance
+func NewMockExternalLister(ctrl *gomock.Controller) *MockExternalLister {
+	mock := &MockExternalLister{ctrl: ctrl}
+	mock.recorder = &MockExternalListerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockExternalLister) EXPECT() *MockExternalListerMockRecorder {
+	return m.recorder
+}
+
+// ListExternal mocks base method
+func (m *MockExternalLister) ListExternal(arg0 types.ResourcePool, arg1 map[string]interface{}) ([]types.Resource, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListExternal", arg0, arg1)
+	ret0, _ := ret[0].([]types.Resource)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListExternal indicates an expected call of ListExternal
+func (mr *MockExternalListerMockRecorder) ListExternal(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListExternal", reflect.TypeOf((*MockExternalLister)(nil).ListExternal), arg0, arg1)
+}
@@ -1,9 +1,155 @@
 package types
 
+import (
+	"context"
+	"errors"
+	"time"
+)
+
 type CmdParam struct {
-	Name string
-	Path string
-	Envs []string
+	Name   string
+	Path   string
+	Args   []string
+	Dir    string
+	Envs   []string
+	Pools  []string
+	SHA256 string
+	Owners []string
+	// CleanEnv stops the plugin from inheriting the host environment, except variables listed in EnvAllowlist.
+	CleanEnv     bool
+	EnvAllowlist []string
+	Rlimits      Rlimits
+	Credential   *Credential
+	// LaunchTimeout is how long the plugin is given to print its listened sign, zero means the default.
+	LaunchTimeout time.Duration
+	Logs          LogConfig
+	// Instances is the number of processes launched for the plugin, zero means one.
+	Instances int
+	// Balance is how calls are spread across instances, either BalanceLoad or BalancePool, empty means BalanceLoad.
+	Balance string
+	// Kind is how the controller is served, empty means KindProcess.
+	Kind     string
+	Webhook  WebhookConfig
+	Exec     ExecConfig
+	Wasm     WasmConfig
+	Starlark StarlarkConfig
+	// Encoding of the rpc calls, either EncodingJSON or EncodingMsgpack, empty means EncodingJSON.
+	// It falls back to EncodingJSON if the plugin doesn't support it.
+	Encoding string
+	// PoolView is how pools are sent to the plugin, either PoolViewFull, PoolViewSummary or PoolViewDelta, empty means PoolViewFull.
+	PoolView string
+}
+
+const (
+	KindProcess  = "process"  // a subprocess serving rpc calls
+	KindWebhook  = "webhook"  // an http service receiving calls as json posts
+	KindExec     = "exec"     // a script executed for each call with the method as its last argument
+	KindWasm     = "wasm"     // a wasm module run in process
+	KindStarlark = "starlark" // a starlark script run in process
+)
+
+// WebhookConfig of a KindWebhook plugin. Calls are posted to URL + "/FindResource", "/SyncResource" and "/ListExternal",
+// and signed by HMAC-SHA256 with the Secret if set.
+type WebhookConfig struct {
+	URL           string
+	Timeout       time.Duration
+	Retries       int
+	RetryInterval time.Duration
+	Secret        string
+	Headers       map[string]string
+}
+
+// ExecConfig of a KindExec plugin, whose Path is executed with the json args on stdin and prints the result to stdout.
+type ExecConfig struct {
+	Timeout time.Duration
+}
+
+// WasmConfig of a KindWasm plugin, whose Path is the wasm module.
+type WasmConfig struct {
+	MemoryLimit  uint64 // max memory of an instance in bytes, zero means the wasm limit
+	Timeout      time.Duration
+	AllowedHosts []string // hosts allowed for the http egress, empty means none
+}
+
+// StarlarkConfig of a KindStarlark plugin, whose script is the Source, or the file at Path if Source is empty.
+type StarlarkConfig struct {
+	Source   string
+	MaxSteps uint64 // max execution steps of a call, zero means the default
+	Timeout  time.Duration
+}
+
+const (
+	EncodingJSON    = "json"
+	EncodingMsgpack = "msgpack"
+)
+
+const (
+	PoolViewFull    = "full"    // send all resources and clients
+	PoolViewSummary = "summary" // send clients without their Meta and PoolConfig
+	PoolViewDelta   = "delta"   // send resources changed since the last call of the pool
+)
+
+const (
+	BalanceLoad = "load" // call the instance with the least outstanding calls
+	BalancePool = "pool" // call the same instance for the same pool
+)
+
+// LogConfig of the plugin output. Lines are kept in a ring buffer of Buffer lines,
+// and written to File if set, or to the host logger otherwise.
+type LogConfig struct {
+	Buffer   int
+	File     string
+	MaxSize  int64 // rotate File once it exceeds MaxSize bytes, zero means never
+	MaxFiles int   // number of rotated files to keep
+	JSON     bool  // parse JSON lines into LogLine.Fields
+}
+
+type LogLine struct {
+	Time   time.Time
+	PID    int
+	Stream string
+	Text   string
+	Fields map[string]interface{} `json:",omitempty"`
+}
+
+// Rlimits of the plugin process, zero means unlimited.
+type Rlimits struct {
+	Memory uint64 // max address space in bytes
+	CPU    uint64 // max cpu time in seconds
+	NoFile uint64 // max number of open files
+}
+
+// Credential is the uid and gid that the plugin process will be run as.
+type Credential struct {
+	UID uint32
+	GID uint32
+}
+
+type PluginStatus struct {
+	Name            string
+	Running         bool
+	Stopped         bool
+	Healthy         bool
+	PID             int
+	StartedAt       time.Time
+	Restarts        int
+	ProtocolVersion int
+	Checksum        string
+	Logs            []LogLine
+	LastError       string
+	LastOutput      []string
+	ExitStatus      string
+	Instances       []InstanceStatus
+}
+
+type InstanceStatus struct {
+	Slot      int
+	Running   bool
+	Healthy   bool
+	PID       int
+	StartedAt time.Time
+	Restarts  int
+	Inflight  int
 }
 
 //go:generate mockgen -destination=mock/controller.go -package=mock github.com/rueian/godemand/types Controller
@@ -12,10 +158,46 @@ type Controller interface {
 	SyncResource(resource Resource, params map[string]interface{}) (Resource, error)
 }
 
+// ExternalLister is optionally implemented by a Controller to report the real resources it manages,
+// so that resources unknown to godemand can be discovered and reconciled.
+//
+//go:generate mockgen -destination=mock/lister.go -package=mock github.com/rueian/godemand/types ExternalLister
+type ExternalLister interface {
+	ListExternal(pool ResourcePool, params map[string]interface{}) ([]Resource, error)
+}
+
+var ExternalListNotSupportedErr = errors.New("controller does not support ListExternal")
+
+// ContextController is optionally implemented by a Controller to receive the context of calls,
+// which carries the trace span of the caller.
+type ContextController interface {
+	FindResourceContext(ctx context.Context, pool ResourcePool, params map[string]interface{}) (Resource, error)
+	SyncResourceContext(ctx context.Context, resource Resource, params map[string]interface{}) (Resource, error)
+}
+
+// FindResource calls the FindResourceContext of the controller if it is a ContextController, or its FindResource otherwise.
+func FindResource(ctx context.Context, c Controller, pool ResourcePool, params map[string]interface{}) (Resource, error) {
+	if cc, ok := c.(ContextController); ok {
+		return cc.FindResourceContext(ctx, pool, params)
+	}
+	return c.FindResource(pool, params)
+}
+
+// SyncResource calls the SyncResourceContext of the controller if it is a ContextController, or its SyncResource otherwise.
+func SyncResource(ctx context.Context, c Controller, resource Resource, params map[string]interface{}) (Resource, error) {
+	if cc, ok := c.(ContextController); ok {
+		return cc.SyncResourceContext(ctx, resource, params)
+	}
+	return c.SyncResource(resource, params)
+}
+
 //go:generate mockgen -destination=mock/launchpad.go -package=mock github.com/rueian/godemand/types Launchpad
 type Launchpad interface {
 	SetLaunchers(params map[string]CmdParam) error
 	GetController(name string) (controller Controller, err error)
+	Status() []PluginStatus
+	Restart(name string) error
+	Stop(name string) error
 	Close()
 }
 
@@ -1,8 +1,16 @@
 package types
 
+import "context"
+
 //go:generate mockgen -destination=mock/service.go -package=mock github.com/rueian/godemand/types Service
 type Service interface {
 	RequestResource(poolID string, client Client) (res Resource, err error)
 	GetResource(poolID, id string) (res Resource, err error)
 	Heartbeat(poolID, id string, client Client) (err error)
 }
+
+// ContextService is optionally implemented by a Service to receive the context of requests,
+// which carries the trace span of the caller.
+type ContextService interface {
+	RequestResourceContext(ctx context.Context, poolID string, client Client) (res Resource, err error)
+}
@@ -0,0 +1,102 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var InvalidResponseErr = errors.New("invalid response from controller")
+
+// ResponseError describes why the resource returned by the controller is rejected.
+type ResponseError struct {
+	PoolID     string
+	ResourceID string
+	Method     string
+	Field      string
+	Reason     string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("invalid %s of resource %q returned by %s of pool %q: %s", e.Field, e.ResourceID, e.Method, e.PoolID, e.Reason)
+}
+
+func (e *ResponseError) Unwrap() error {
+	return InvalidResponseErr
+}
+
+// ValidateFound checks the resource returned by FindResource before it is saved into the pool.
+// A new resource should start in the pending, booting or serving state, while an existing one should not be going away.
+func ValidateFound(pool ResourcePool, res Resource) error {
+	invalid := func(field, reason string) error {
+		return &ResponseError{PoolID: pool.ID, ResourceID: res.ID, Method: "FindResource", Field: field, Reason: reason}
+	}
+
+	if res.ID == "" {
+		return invalid("ID", "empty")
+	}
+	if res.PoolID != "" && res.PoolID != pool.ID {
+		return invalid("PoolID", fmt.Sprintf("belongs to pool %q", res.PoolID))
+	}
+
+	if _, ok := pool.Resources[res.ID]; ok {
+		switch res.State {
+		case ResourcePending, ResourceBooting, ResourceServing, ResourceUnknown, ResourceError:
+		default:
+			return invalid("State", fmt.Sprintf("%s is illegal for existing resources", stateName(res.State)))
+		}
+	} else {
+		switch res.State {
+		case ResourcePending, ResourceBooting, ResourceServing:
+		default:
+			return invalid("State", fmt.Sprintf("%s is illegal for new resources", stateName(res.State)))
+		}
+	}
+
+	if _, err := json.Marshal(res.Meta); err != nil {
+		return invalid("Meta", err.Error())
+	}
+	return nil
+}
+
+// ValidateSynced checks the resource returned by SyncResource of the res before it is saved into the pool.
+// It should be the same resource of the same pool in a known state.
+func ValidateSynced(res, ret Resource) error {
+	invalid := func(field, reason string) error {
+		return &ResponseError{PoolID: res.PoolID, ResourceID: res.ID, Method: "SyncResource", Field: field, Reason: reason}
+	}
+
+	if ret.ID == "" {
+		return invalid("ID", "empty")
+	}
+	if ret.ID != res.ID {
+		return invalid("ID", fmt.Sprintf("changed to %q", ret.ID))
+	}
+	if ret.PoolID != res.PoolID {
+		return invalid("PoolID", fmt.Sprintf("belongs to pool %q", ret.PoolID))
+	}
+	if !knownState(ret.State) {
+		return invalid("State", fmt.Sprintf("%s is unknown", stateName(ret.State)))
+	}
+
+	if _, err := json.Marshal(ret.Meta); err != nil {
+		return invalid("Meta", err.Error())
+	}
+	return nil
+}
+
+func stateName(s ResourceState) string {
+	if knownState(s) {
+		return s.String()
+	}
+	return fmt.Sprintf("state %d", s)
+}
+
+func knownState(s ResourceState) bool {
+	for _, known := range ResourceStates {
+		if s == known {
+			return true
+		}
+	}
+	return false
+}
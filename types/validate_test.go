@@ -0,0 +1,92 @@
+package types
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ValidateFound", func() {
+	var pool ResourcePool
+
+	BeforeEach(func() {
+		pool = ResourcePool{ID: "pool1", Resources: map[string]Resource{
+			"a": {ID: "a", PoolID: "pool1", State: ResourceServing},
+		}}
+	})
+
+	invalid := func(res Resource, field string) {
+		err := ValidateFound(pool, res)
+		Expect(errors.Is(err, InvalidResponseErr)).To(BeTrue())
+		var rerr *ResponseError
+		Expect(errors.As(err, &rerr)).To(BeTrue())
+		Expect(rerr.Field).To(Equal(field))
+	}
+
+	It("accept valid resources", func() {
+		Expect(ValidateFound(pool, Resource{ID: "a", State: ResourceError})).NotTo(HaveOccurred())
+		Expect(ValidateFound(pool, Resource{ID: "b", PoolID: "pool1", Meta: Meta{"a": 1}})).NotTo(HaveOccurred())
+	})
+
+	It("reject empty id", func() {
+		invalid(Resource{}, "ID")
+	})
+
+	It("reject foreign pool id", func() {
+		invalid(Resource{ID: "a", PoolID: "pool2"}, "PoolID")
+	})
+
+	It("reject existing resources going away", func() {
+		invalid(Resource{ID: "a", State: ResourceDeleted}, "State")
+		invalid(Resource{ID: "a", State: ResourceTerminating}, "State")
+	})
+
+	It("reject new resources not starting", func() {
+		invalid(Resource{ID: "b", State: ResourceError}, "State")
+		invalid(Resource{ID: "b", State: ResourceState(100)}, "State")
+	})
+
+	It("reject meta unable to be serialized", func() {
+		invalid(Resource{ID: "b", Meta: Meta{"ch": make(chan int)}}, "Meta")
+	})
+})
+
+var _ = Describe("ValidateSynced", func() {
+	var res Resource
+
+	BeforeEach(func() {
+		res = Resource{ID: "a", PoolID: "pool1", State: ResourceBooting}
+	})
+
+	invalid := func(ret Resource, field string) {
+		err := ValidateSynced(res, ret)
+		Expect(errors.Is(err, InvalidResponseErr)).To(BeTrue())
+		var rerr *ResponseError
+		Expect(errors.As(err, &rerr)).To(BeTrue())
+		Expect(rerr.Method).To(Equal("SyncResource"))
+		Expect(rerr.Field).To(Equal(field))
+	}
+
+	It("accept valid resources", func() {
+		Expect(ValidateSynced(res, Resource{ID: "a", PoolID: "pool1", State: ResourceDeleted, Meta: Meta{"a": 1}})).NotTo(HaveOccurred())
+	})
+
+	It("reject empty or changed id", func() {
+		invalid(Resource{PoolID: "pool1"}, "ID")
+		invalid(Resource{ID: "b", PoolID: "pool1"}, "ID")
+	})
+
+	It("reject changed pool id", func() {
+		invalid(Resource{ID: "a"}, "PoolID")
+		invalid(Resource{ID: "a", PoolID: "pool2"}, "PoolID")
+	})
+
+	It("reject unknown state", func() {
+		invalid(Resource{ID: "a", PoolID: "pool1", State: ResourceState(100)}, "State")
+	})
+
+	It("reject meta unable to be serialized", func() {
+		invalid(Resource{ID: "a", PoolID: "pool1", Meta: Meta{"ch": make(chan int)}}, "Meta")
+	})
+})